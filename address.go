@@ -0,0 +1,325 @@
+package ksef
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/org"
+)
+
+var (
+	// Polish postcodes, like 00-950
+	plPostCodeRegexp         = regexp.MustCompile(`^\d{2}-\d{3}$`)
+	plPostCodeLocalityRegexp = regexp.MustCompile(`^(\d{2}-\d{3})\s+(.+)$`)
+
+	// Foreign postcodes starting with a digit, with an optional country
+	// prefix, like 10115 or D-10115
+	postCodeRegexp         = regexp.MustCompile(`^(?:[A-Z]{1,2}-)?\d[\dA-Z-]*$`)
+	postCodeLocalityRegexp = regexp.MustCompile(`^((?:[A-Z]{1,2}-)?\d[\dA-Z-]*)\s+(.+)$`)
+
+	// Postcodes following the locality, like London SW1A 2AA, Boston 02108
+	// or Toronto M5V 2T6
+	localityPostCodeRegexp = regexp.MustCompile(`^(.+?)\s+([A-Z]{1,2}\d[A-Z\d]?\s?\d[A-Z]{2}|\d{5}(?:-\d{4})?|[A-Z]\d[A-Z]\s?\d[A-Z]\d)$`)
+
+	// Streets followed by the building number and an optional door, like
+	// ul. Marszałkowska 12/3 or ul. Marszałkowska 12 m. 3
+	streetNumberRegexp = regexp.MustCompile(`^(.+?)\s+(\d+[A-Za-z]?(?:-\d+[A-Za-z]?)?)(?:\s*/\s*(\w+)|\s+(?:m\.|lok\.)\s*(\w+))?$`)
+	// Building numbers preceding the street, like 10 Downing Street
+	numberStreetRegexp = regexp.MustCompile(`^(\d+[A-Za-z]?)\s+(.+)$`)
+
+	// Post office boxes, like skr. poczt. 12 or PO Box 12
+	postOfficeBoxRegexp = regexp.MustCompile(`(?i)^(?:skr\. poczt\.|skrytka pocztowa|P\.?O\.? Box)\s+(.+)$`)
+	// Blocks and floors, like bl. 4 or Block B, and piętro 2 or Floor 3
+	blockRegexp = regexp.MustCompile(`(?i)^(?:bl\.|blok|block)\s+(.+)$`)
+	floorRegexp = regexp.MustCompile(`(?i)^(?:piętro|floor)\s+(.+)$`)
+)
+
+// maxAddressLine is the maximum number of characters of an address line
+const maxAddressLine = 512
+
+// Countries writing the building number before the street, and the
+// postcode after the locality
+var (
+	numberFirstCountries  = []l10n.ISOCountryCode{"GB", "IE", "US", "CA", "AU", "FR"}
+	postCodeLastCountries = []l10n.ISOCountryCode{"GB", "US", "CA"}
+)
+
+// newAddress gets the address data from GOBL address. The first line holds
+// the street, building number and door, followed by the street extra, and the
+// block, floor and post office box with their markers, and the second line
+// the postcode and locality, followed by the region. It is the layout of the
+// official samples, which parseAddress decomposes back:
+//
+//	ul. Marszałkowska 12 m. 3
+//	00-950 Warszawa
+//
+// Lines over 512 characters are wrapped at a space into the second line,
+// and cut at 512 characters there.
+func newAddress(address *org.Address) *Address {
+	line1 := streetLine(address)
+	line2 := localityLine(address)
+	if utf8.RuneCountInString(line1) > maxAddressLine {
+		var rest string
+		line1, rest = wrapAddressLine(line1)
+		line2 = joinAddressParts(rest, line2)
+	}
+	if line1 == "" {
+		line1, line2 = line2, ""
+	}
+	line2, _ = wrapAddressLine(line2)
+
+	return &Address{
+		CountryCode: string(address.Country),
+		AddressL1:   line1,
+		AddressL2:   line2,
+	}
+}
+
+func streetLine(address *org.Address) string {
+	polish := address.Country == l10n.PL.ISO()
+	number := address.Number
+	if number != "" && address.Door != "" {
+		if polish {
+			number += " m. " + address.Door
+		} else {
+			number += "/" + address.Door
+		}
+	}
+
+	street := address.Street
+	if number != "" {
+		if slices.Contains(numberFirstCountries, address.Country) {
+			street = strings.TrimSpace(number + " " + street)
+		} else {
+			street = strings.TrimSpace(street + " " + number)
+		}
+	}
+
+	return joinAddressParts(street, address.StreetExtra,
+		addressMarker(address.Block, "bl. ", "Block ", polish),
+		addressMarker(address.Floor, "piętro ", "Floor ", polish),
+		addressMarker(address.PostOfficeBox, "skr. poczt. ", "PO Box ", polish))
+}
+
+// addressMarker prefixes the address part with its Polish or English marker,
+// so that it can be told apart from the street extra when parsed.
+func addressMarker(part, pl, en string, polish bool) string {
+	if part == "" {
+		return ""
+	}
+	if polish {
+		return pl + part
+	}
+	return en + part
+}
+
+func localityLine(address *org.Address) string {
+	line := strings.TrimSpace(address.Code.String() + " " + address.Locality)
+	if slices.Contains(postCodeLastCountries, address.Country) {
+		line = strings.TrimSpace(address.Locality + " " + address.Code.String())
+	}
+	return joinAddressParts(line, address.Region)
+}
+
+func joinAddressParts(parts ...string) string {
+	var line []string
+	for _, p := range parts {
+		if p != "" {
+			line = append(line, p)
+		}
+	}
+	return strings.Join(line, ", ")
+}
+
+// wrapAddressLine cuts the line at the last space within the maximum number
+// of characters, or at the maximum when there is none, and returns the rest.
+func wrapAddressLine(line string) (string, string) {
+	runes := []rune(line)
+	if len(runes) <= maxAddressLine {
+		return line, ""
+	}
+	cut := maxAddressLine
+	for i := maxAddressLine; i > 0; i-- {
+		if runes[i] == ' ' {
+			cut = i
+			break
+		}
+	}
+	return strings.TrimRight(string(runes[:cut]), ", "), strings.TrimSpace(string(runes[cut:]))
+}
+
+// parseAddress converts a KSEF Address to a GOBL Address. The address lines
+// are kept in the street when they cannot be decomposed.
+func parseAddress(addr *Address) *org.Address {
+	if address, ok := decomposeAddress(addr); ok {
+		return address
+	}
+
+	return &org.Address{
+		Country: l10n.ISOCountryCode(addr.CountryCode),
+		Street:  addressLines(addr),
+	}
+}
+
+// decomposeAddress splits the address lines into the street, number, door,
+// postcode and locality, and reports whether the lines could be decomposed.
+// The postcode and locality are expected at the end of the lines, separated
+// from the street by a comma or on the second line, and optionally followed
+// by the region. Further parts after the street are kept in the street extra,
+// except post office boxes.
+func decomposeAddress(addr *Address) (*org.Address, bool) {
+	parts := addressParts(addr.AddressL1)
+	parts = append(parts, addressParts(addr.AddressL2)...)
+	if len(parts) == 0 {
+		return nil, false
+	}
+
+	address := &org.Address{
+		Country: l10n.ISOCountryCode(addr.CountryCode),
+	}
+	polish := addr.CountryCode == "" || addr.CountryCode == l10n.PL.String()
+
+	var code, locality, prev string
+	n := len(parts)
+	last := parts[n-1]
+	if n > 1 {
+		prev = parts[n-2]
+	}
+	if m := postCodeLocality(last, polish); m != nil {
+		code, locality = m[1], m[2]
+		parts = parts[:n-1]
+	} else if isPostCode(prev, polish) {
+		code, locality = prev, last
+		parts = parts[:n-2]
+	} else if m := localityPostCode(last, polish); m != nil {
+		locality, code = m[1], m[2]
+		parts = parts[:n-1]
+	} else if m := postCodeLocality(prev, polish); m != nil {
+		code, locality, address.Region = m[1], m[2], last
+		parts = parts[:n-2]
+	} else if m := localityPostCode(prev, polish); m != nil {
+		locality, code, address.Region = m[1], m[2], last
+		parts = parts[:n-2]
+	} else {
+		return nil, false
+	}
+	if !strings.ContainsFunc(locality, unicode.IsLetter) {
+		return nil, false
+	}
+	address.Code = cbc.Code(code)
+	address.Locality = locality
+
+	var extra []string
+	for i, part := range parts {
+		if m := postOfficeBoxRegexp.FindStringSubmatch(part); m != nil {
+			address.PostOfficeBox = m[1]
+		} else if m := blockRegexp.FindStringSubmatch(part); m != nil && i > 0 {
+			address.Block = m[1]
+		} else if m := floorRegexp.FindStringSubmatch(part); m != nil && i > 0 {
+			address.Floor = m[1]
+		} else if i == 0 {
+			parseStreet(address, part, polish)
+		} else {
+			extra = append(extra, part)
+		}
+	}
+	address.StreetExtra = strings.Join(extra, ", ")
+
+	return address, true
+}
+
+func parseStreet(address *org.Address, street string, polish bool) {
+	if m := streetNumberRegexp.FindStringSubmatch(street); m != nil {
+		address.Street = m[1]
+		address.Number = m[2]
+		address.Door = m[3] + m[4]
+	} else if m := numberStreetRegexp.FindStringSubmatch(street); m != nil && !polish {
+		address.Number = m[1]
+		address.Street = m[2]
+	} else {
+		address.Street = street
+	}
+}
+
+func postCodeLocality(part string, polish bool) []string {
+	if polish {
+		return plPostCodeLocalityRegexp.FindStringSubmatch(part)
+	}
+	return postCodeLocalityRegexp.FindStringSubmatch(part)
+}
+
+func localityPostCode(part string, polish bool) []string {
+	if polish {
+		return nil
+	}
+	return localityPostCodeRegexp.FindStringSubmatch(part)
+}
+
+func isPostCode(part string, polish bool) bool {
+	if polish {
+		return plPostCodeRegexp.MatchString(part)
+	}
+	return postCodeRegexp.MatchString(part)
+}
+
+// addressParts splits an address line at its commas
+func addressParts(line string) []string {
+	var parts []string
+	for _, p := range strings.Split(line, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+func addressLines(addr *Address) string {
+	line := addr.AddressL1
+	if addr.AddressL2 != "" {
+		line += " " + addr.AddressL2
+	}
+	return line
+}
+
+// addressWarnings reports the parsed addresses that could not be decomposed
+// and were kept in the street.
+func (d *Invoice) addressWarnings() []string {
+	var warnings []string
+	check := func(path string, addr *Address) {
+		if addr == nil {
+			return
+		}
+		if _, ok := decomposeAddress(addr); !ok {
+			warnings = append(warnings, fmt.Sprintf("%s: address '%s' not recognised, kept as street", path, addressLines(addr)))
+		}
+	}
+
+	if d.Seller != nil {
+		check("Podmiot1>Adres", d.Seller.Address)
+		check("Podmiot1>AdresKoresp", d.Seller.CorrespondenceAddress)
+	}
+	if d.Buyer != nil {
+		check("Podmiot2>Adres", d.Buyer.Address)
+		check("Podmiot2>AdresKoresp", d.Buyer.CorrespondenceAddress)
+	}
+	for _, tp := range d.ThirdParties {
+		if !tp.isIdentityRole() {
+			check("Podmiot3>Adres", tp.Address)
+			check("Podmiot3>AdresKoresp", tp.CorrespondenceAddress)
+		}
+	}
+	if d.Authorized != nil {
+		check("PodmiotUpowazniony>Adres", d.Authorized.Address)
+	}
+	if tc := d.Inv.TransactionConditions; tc != nil && len(tc.Transport) > 0 {
+		check("Fa>WarunkiTransakcji>Transport>WysylkaDo", tc.Transport[0].ShipTo)
+	}
+
+	return warnings
+}
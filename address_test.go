@@ -0,0 +1,266 @@
+package ksef_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	ksef "github.com/invopop/gobl.ksef"
+	"github.com/invopop/gobl.ksef/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddress(t *testing.T) {
+	parse := func(t *testing.T, addr *ksef.Address) *org.Address {
+		t.Helper()
+		seller := &ksef.Seller{NIP: "1234567890", Name: "Test", Address: addr}
+		party := seller.ToGOBL()
+		require.Len(t, party.Addresses, 1)
+		return party.Addresses[0]
+	}
+
+	tests := []struct {
+		name     string
+		addr     *ksef.Address
+		expected *org.Address
+	}{
+		{
+			name: "Polish address with door",
+			addr: &ksef.Address{CountryCode: "PL", AddressL1: "ul. Marszałkowska 12/3, 00-950 Warszawa"},
+			expected: &org.Address{
+				Country: "PL", Street: "ul. Marszałkowska", Number: "12", Door: "3",
+				Code: "00-950", Locality: "Warszawa",
+			},
+		},
+		{
+			name: "Polish address with flat marker",
+			addr: &ksef.Address{CountryCode: "PL", AddressL1: "ul. Długa 5A m. 14, 80-831 Gdańsk"},
+			expected: &org.Address{
+				Country: "PL", Street: "ul. Długa", Number: "5A", Door: "14",
+				Code: "80-831", Locality: "Gdańsk",
+			},
+		},
+		{
+			name: "Polish address on two lines",
+			addr: &ksef.Address{CountryCode: "PL", AddressL1: "al. Jerozolimskie 100", AddressL2: "00-807 Warszawa"},
+			expected: &org.Address{
+				Country: "PL", Street: "al. Jerozolimskie", Number: "100",
+				Code: "00-807", Locality: "Warszawa",
+			},
+		},
+		{
+			name: "Polish address generated by the conversion",
+			addr: &ksef.Address{CountryCode: "PL", AddressL1: "ul. Główna 1, 00-001, Warszawa"},
+			expected: &org.Address{
+				Country: "PL", Street: "ul. Główna", Number: "1",
+				Code: "00-001", Locality: "Warszawa",
+			},
+		},
+		{
+			name: "Polish village without street",
+			addr: &ksef.Address{CountryCode: "PL", AddressL1: "Zalesie, 05-500 Piaseczno"},
+			expected: &org.Address{
+				Country: "PL", Street: "Zalesie",
+				Code: "05-500", Locality: "Piaseczno",
+			},
+		},
+		{
+			name: "German address on two lines",
+			addr: &ksef.Address{CountryCode: "DE", AddressL1: "Am Kai 14", AddressL2: "20457 Hamburg"},
+			expected: &org.Address{
+				Country: "DE", Street: "Am Kai", Number: "14",
+				Code: "20457", Locality: "Hamburg",
+			},
+		},
+		{
+			name: "British address",
+			addr: &ksef.Address{CountryCode: "GB", AddressL1: "10 Downing Street, London SW1A 2AA"},
+			expected: &org.Address{
+				Country: "GB", Street: "Downing Street", Number: "10",
+				Code: "SW1A 2AA", Locality: "London",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, parse(t, tt.addr))
+		})
+	}
+
+	t.Run("keeps unrecognised address in street", func(t *testing.T) {
+		addr := parse(t, &ksef.Address{CountryCode: "PL", AddressL1: "Skrytka pocztowa 12", AddressL2: "Warszawa"})
+
+		assert.Equal(t, l10n.ISOCountryCode("PL"), addr.Country)
+		assert.Equal(t, "Skrytka pocztowa 12 Warszawa", addr.Street)
+		assert.Empty(t, addr.Code)
+		assert.Empty(t, addr.Locality)
+	})
+
+	t.Run("does not take Polish postcodes from foreign addresses", func(t *testing.T) {
+		addr := parse(t, &ksef.Address{CountryCode: "PL", AddressL1: "Hauptstraße 5, 10115 Berlin"})
+
+		assert.Equal(t, "Hauptstraße 5, 10115 Berlin", addr.Street)
+	})
+}
+
+func TestNewAddress(t *testing.T) {
+	build := func(addr *org.Address) *ksef.Address {
+		supplier := &org.Party{
+			Name:      "Test",
+			TaxID:     &tax.Identity{Country: l10n.PL.Tax(), Code: "1234567890"},
+			Addresses: []*org.Address{addr},
+		}
+		return ksef.NewFavatSeller(supplier).Address
+	}
+
+	tests := []struct {
+		name  string
+		addr  *org.Address
+		line1 string
+		line2 string
+	}{
+		{
+			name: "Polish address with door",
+			addr: &org.Address{
+				Country: "PL", Street: "ul. Marszałkowska", Number: "12", Door: "3",
+				Code: "00-950", Locality: "Warszawa",
+			},
+			line1: "ul. Marszałkowska 12 m. 3",
+			line2: "00-950 Warszawa",
+		},
+		{
+			name: "Polish address with street extra and region",
+			addr: &org.Address{
+				Country: "PL", Street: "ul. Przemysłowa", Number: "5", StreetExtra: "Hala C",
+				Code: "62-080", Locality: "Tarnowo Podgórne", Region: "wielkopolskie",
+			},
+			line1: "ul. Przemysłowa 5, Hala C",
+			line2: "62-080 Tarnowo Podgórne, wielkopolskie",
+		},
+		{
+			name: "Polish address with block and floor",
+			addr: &org.Address{
+				Country: "PL", Street: "ul. Słoneczna", Number: "7", Door: "21",
+				Block: "4", Floor: "2", Code: "31-980", Locality: "Kraków",
+			},
+			line1: "ul. Słoneczna 7 m. 21, bl. 4, piętro 2",
+			line2: "31-980 Kraków",
+		},
+		{
+			name: "German address with street extra, block and floor",
+			addr: &org.Address{
+				Country: "DE", Street: "Am Kai", Number: "14", StreetExtra: "Hinterhaus",
+				Block: "B", Floor: "3", Code: "20457", Locality: "Hamburg",
+			},
+			line1: "Am Kai 14, Hinterhaus, Block B, Floor 3",
+			line2: "20457 Hamburg",
+		},
+		{
+			name: "Polish post office box",
+			addr: &org.Address{
+				Country: "PL", PostOfficeBox: "12", Code: "00-950", Locality: "Warszawa",
+			},
+			line1: "skr. poczt. 12",
+			line2: "00-950 Warszawa",
+		},
+		{
+			name: "German address with door",
+			addr: &org.Address{
+				Country: "DE", Street: "Am Kai", Number: "14", Door: "2",
+				Code: "20457", Locality: "Hamburg",
+			},
+			line1: "Am Kai 14/2",
+			line2: "20457 Hamburg",
+		},
+		{
+			name: "British address",
+			addr: &org.Address{
+				Country: "GB", Street: "Downing Street", Number: "10",
+				Code: "SW1A 2AA", Locality: "London",
+			},
+			line1: "10 Downing Street",
+			line2: "London SW1A 2AA",
+		},
+		{
+			name:  "address with locality only",
+			addr:  &org.Address{Country: "PL", Locality: "Warszawa"},
+			line1: "Warszawa",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := build(tt.addr)
+			assert.Equal(t, tt.line1, addr.AddressL1)
+			assert.Equal(t, tt.line2, addr.AddressL2)
+		})
+
+		if tt.line2 == "" {
+			continue
+		}
+		t.Run(tt.name+" is parsed back", func(t *testing.T) {
+			seller := &ksef.Seller{NIP: "1234567890", Name: "Test", Address: build(tt.addr)}
+			party := seller.ToGOBL()
+			require.Len(t, party.Addresses, 1)
+			assert.Equal(t, tt.addr, party.Addresses[0])
+		})
+	}
+
+	t.Run("cuts lines without spaces at 512 characters", func(t *testing.T) {
+		addr := build(&org.Address{
+			Country:  "PL",
+			Street:   strings.Repeat("ł", 600),
+			Code:     "00-950",
+			Locality: "Warszawa",
+		})
+
+		assert.Equal(t, 512, utf8.RuneCountInString(addr.AddressL1))
+		assert.Equal(t, strings.Repeat("ł", 88)+", 00-950 Warszawa", addr.AddressL2)
+		assert.True(t, utf8.ValidString(addr.AddressL1))
+	})
+}
+
+func TestAddressWarnings(t *testing.T) {
+	load := func(t *testing.T) []byte {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", "invoice-standard.xml"))
+		require.NoError(t, err)
+		return data
+	}
+
+	t.Run("reports no warnings for recognised addresses", func(t *testing.T) {
+		var warnings []string
+		_, err := ksef.ParseKSeF(load(t), ksef.WithWarningHandler(func(w string) {
+			warnings = append(warnings, w)
+		}))
+		require.NoError(t, err)
+
+		assert.Empty(t, warnings)
+	})
+
+	t.Run("reports addresses kept in street", func(t *testing.T) {
+		data := strings.Replace(string(load(t)),
+			"<AdresL1>ul. Testowa 10, 30-001, Kraków</AdresL1>",
+			"<AdresL1>Skrytka pocztowa 12</AdresL1>", 1)
+
+		var warnings []string
+		env, err := ksef.ParseKSeF([]byte(data), ksef.WithWarningHandler(func(w string) {
+			warnings = append(warnings, w)
+		}))
+		require.NoError(t, err)
+
+		require.Len(t, warnings, 1)
+		assert.Equal(t, "Podmiot2>Adres: address 'Skrytka pocztowa 12' not recognised, kept as street", warnings[0])
+
+		inv := env.Extract().(*bill.Invoice)
+		assert.Equal(t, "Skrytka pocztowa 12", inv.Customer.Addresses[0].Street)
+		assert.Equal(t, cbc.Code(""), inv.Customer.Addresses[0].Code)
+	})
+}
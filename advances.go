@@ -0,0 +1,339 @@
+package ksef
+
+import (
+	"fmt"
+
+	"github.com/invopop/gobl/addons/pl/favat"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/head"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/pay"
+	"github.com/invopop/gobl/tax"
+)
+
+// maxAdvanceInvoices is the maximum number of advance invoice references
+// allowed by the schema
+const maxAdvanceInvoices = 100
+
+// maxPartialAdvancePayments is the maximum number of partial advance payments
+// allowed by the schema
+const maxPartialAdvancePayments = 31
+
+// AdvanceInvoiceRef defines the XML structure for advance invoice reference
+type AdvanceInvoiceRef struct {
+	KSeFMarker           int    `xml:"NrKSeFZN,omitempty"`
+	AdvanceInvoiceNo     string `xml:"NrFaZaliczkowej,omitempty"`
+	KSeFAdvanceInvoiceNo string `xml:"NrKSeFFaZaliczkowej,omitempty"`
+}
+
+// PartialAdvancePayment defines the XML structure for partial advance payment (ZaliczkaCzesciowa)
+type PartialAdvancePayment struct {
+	PaymentDate          string `xml:"P_6Z"`
+	PaymentAmount        string `xml:"P_15Z"`
+	CurrencyExchangeRate string `xml:"KursWalutyZW,omitempty"`
+}
+
+// NewAdvanceInvoiceRefs builds the references to the advance invoices settled
+// by a ROZ invoice from the preceding documents and the payment advances with
+// a reference to the advance invoice number.
+func NewAdvanceInvoiceRefs(inv *bill.Invoice) []*AdvanceInvoiceRef {
+	var refs []*AdvanceInvoiceRef
+	numbers := make(map[string]bool)
+
+	for _, prc := range inv.Preceding {
+		number := invoiceNumber(prc.Series, prc.Code)
+		numbers[number] = true
+		if id := findStamp(prc.Stamps, favat.StampKSEFNumber); id != -1 {
+			numbers[prc.Stamps[id].Value] = true
+		}
+		refs = append(refs, newAdvanceInvoiceRef(number, prc.Stamps))
+	}
+
+	// Advances referring to a preceding document are already included
+	if inv.Payment != nil {
+		for _, adv := range inv.Payment.Advances {
+			if adv.Ref != "" && !numbers[adv.Ref] {
+				numbers[adv.Ref] = true
+				refs = append(refs, newAdvanceInvoiceRef(adv.Ref, nil))
+			}
+		}
+	}
+
+	if len(refs) > maxAdvanceInvoices {
+		refs = refs[:maxAdvanceInvoices]
+	}
+
+	return refs
+}
+
+func newAdvanceInvoiceRef(number string, stamps []*head.Stamp) *AdvanceInvoiceRef {
+	if id := findStamp(stamps, favat.StampKSEFNumber); id != -1 {
+		return &AdvanceInvoiceRef{
+			KSeFAdvanceInvoiceNo: stamps[id].Value,
+		}
+	}
+	return &AdvanceInvoiceRef{
+		KSeFMarker:       1,
+		AdvanceInvoiceNo: number,
+	}
+}
+
+// parseAdvanceInvoices converts the references to the advance invoices
+// settled by the invoice into preceding documents, stamped with the KSeF
+// number when there is one. KSeF does not give the issue date of the advance
+// invoices, which GOBL requires, so the issue date of the settlement invoice
+// is set as the latest possible one and a warning is reported.
+func (d *Invoice) parseAdvanceInvoices(goblInv *bill.Invoice) {
+	for _, ref := range d.Inv.AdvanceInvoices {
+		date := goblInv.IssueDate
+		prc := &org.DocumentRef{
+			IssueDate: &date,
+			Code:      cbc.Code(ref.AdvanceInvoiceNo),
+		}
+		if ref.KSeFAdvanceInvoiceNo != "" {
+			prc.Code = cbc.Code(ref.KSeFAdvanceInvoiceNo)
+			prc.Stamps = []*head.Stamp{
+				{
+					Provider: favat.StampKSEFNumber,
+					Value:    ref.KSeFAdvanceInvoiceNo,
+				},
+			}
+		}
+		goblInv.Preceding = append(goblInv.Preceding, prc)
+		d.Warnings = append(d.Warnings, fmt.Sprintf("Fa>FakturaZaliczkowa: issue date of advance invoice %s unknown, set to %s", prc.Code, date))
+	}
+}
+
+// parseAdvanceReceived adds the amount received on an advance invoice (P_15)
+// as a payment advance, when it is not the whole order value and the payment
+// details do not include the advances.
+func (inv *Inv) parseAdvanceReceived(goblInv *bill.Invoice) error {
+	if inv.Order == nil || (goblInv.Payment != nil && len(goblInv.Payment.Advances) > 0) {
+		return nil
+	}
+	total, err := inv.Order.total()
+	if err != nil {
+		return err
+	}
+	received, err := parseAmount(inv.TotalAmountDue)
+	if err != nil {
+		return fmt.Errorf("parsing advance received: %w", err)
+	}
+	if received.Equals(total) {
+		return nil
+	}
+
+	// Advances without a payment date are received on the invoice issue date
+	date := goblInv.IssueDate
+	if inv.Payment != nil && inv.Payment.PaymentDate != "" {
+		date, err = parseDate(inv.Payment.PaymentDate)
+		if err != nil {
+			return fmt.Errorf("parsing advance date: %w", err)
+		}
+	}
+	if goblInv.Payment == nil {
+		goblInv.Payment = new(bill.PaymentDetails)
+	}
+	goblInv.Payment.Advances = append(goblInv.Payment.Advances, &pay.Advance{
+		Date:        &date,
+		Description: "Advance payment", // GOBL requires a description
+		Amount:      received,
+	})
+	return nil
+}
+
+// orderAmountDue returns the amount due expected on an advance invoice, which
+// is the value of the order less the advances received, or an empty string for
+// other invoices.
+func (inv *Inv) orderAmountDue(goblInv *bill.Invoice) (string, error) {
+	if inv.Order == nil {
+		return "", nil
+	}
+	total, err := inv.Order.total()
+	if err != nil {
+		return "", err
+	}
+	if goblInv.Payment != nil {
+		for _, adv := range goblInv.Payment.Advances {
+			total = total.Subtract(adv.Amount)
+		}
+	}
+	return total.String(), nil
+}
+
+// invoicedTaxes returns the tax totals of the amounts invoiced. Advance
+// invoices are issued for the advances received, which are split between the
+// VAT rates of the order in proportion to the value of each rate including
+// tax, the last rate taking the remainder so that the amounts add up to the
+// advances.
+func invoicedTaxes(inv *bill.Invoice) *tax.Total {
+	taxes := inv.Totals.Taxes
+	if !inv.HasTags(tax.TagPartial) || inv.Totals.Advances == nil || taxes == nil {
+		return taxes
+	}
+	vat := taxes.Category(tax.CategoryVAT)
+	if vat == nil || len(vat.Rates) == 0 {
+		return taxes
+	}
+
+	var total num.Amount
+	for _, rate := range vat.Rates {
+		gross := rate.Base.Add(rate.Amount)
+		total = total.MatchPrecision(gross).Add(gross)
+	}
+	if total.IsZero() {
+		return taxes
+	}
+
+	received := *inv.Totals.Advances
+	exp := received.Exp()
+	remainder := received
+	sum := num.MakeAmount(0, exp)
+	rates := make([]*tax.RateTotal, len(vat.Rates))
+	for i, rate := range vat.Rates {
+		portion := remainder
+		if i < len(vat.Rates)-1 {
+			portion = received.Upscale(6).Multiply(rate.Base.Add(rate.Amount)).Divide(total).Rescale(exp)
+			remainder = remainder.Subtract(portion)
+		}
+		amount := num.MakeAmount(0, exp)
+		if rate.Percent != nil {
+			amount = rate.Percent.From(portion.Upscale(6)).Rescale(exp)
+		}
+		rates[i] = &tax.RateTotal{
+			Key:     rate.Key,
+			Ext:     rate.Ext,
+			Percent: rate.Percent,
+			Base:    portion.Subtract(amount),
+			Amount:  amount,
+		}
+		sum = sum.Add(amount)
+	}
+
+	return &tax.Total{
+		Sum: sum,
+		Categories: []*tax.CategoryTotal{
+			{
+				Code:   tax.CategoryVAT,
+				Rates:  rates,
+				Amount: sum,
+			},
+		},
+	}
+}
+
+// validatePartialAdvancePayments checks that the payments received on an
+// advance invoice can all be reported, as the totals include every one of
+// them.
+func validatePartialAdvancePayments(inv *bill.Invoice) error {
+	if !inv.HasTags(tax.TagPartial) || inv.Payment == nil {
+		return nil
+	}
+	if n := len(inv.Payment.Advances); n > maxPartialAdvancePayments {
+		return fmt.Errorf("%d advance payments, maximum is %d", n, maxPartialAdvancePayments)
+	}
+	return nil
+}
+
+// NewPartialAdvancePayments documents each of the payments received on an
+// advance invoice, when there is more than one. For foreign currencies, the
+// exchange rate to PLN of each payment is the one set at the payment date, or
+// the invoice exchange rate otherwise.
+func NewPartialAdvancePayments(inv *bill.Invoice) []*PartialAdvancePayment {
+	if !inv.HasTags(tax.TagPartial) || inv.Payment == nil || len(inv.Payment.Advances) < 2 {
+		return nil
+	}
+
+	var payments []*PartialAdvancePayment
+	for _, adv := range inv.Payment.Advances {
+		// Payments without a date are received on the invoice issue date
+		date := inv.IssueDate
+		if adv.Date != nil {
+			date = *adv.Date
+		}
+		p := &PartialAdvancePayment{
+			PaymentDate:   date.String(),
+			PaymentAmount: adv.Amount.String(),
+		}
+		if rate := plnExchangeRate(inv, &date); rate != nil {
+			p.CurrencyExchangeRate = rate.Amount.String()
+		}
+		payments = append(payments, p)
+	}
+
+	return payments
+}
+
+// plnExchangeRate returns the exchange rate from the invoice currency to PLN
+// set at the given date, or the first one without a date, ignoring the rate
+// agreed in the contract.
+func plnExchangeRate(inv *bill.Invoice, date *cal.Date) *currency.ExchangeRate {
+	var match *currency.ExchangeRate
+	for _, rate := range inv.ExchangeRates {
+		if rate.Source == ExchangeRateSourceContract || rate.From != inv.Currency || rate.To != currency.PLN {
+			continue
+		}
+		if rate.At == nil {
+			if match == nil {
+				match = rate
+			}
+			continue
+		}
+		if date != nil && rate.At.Date() == *date {
+			return rate
+		}
+	}
+	return match
+}
+
+// parsePartialAdvancePayments adds the exchange rate of each partial payment
+// at its date, and creates the payment advances when the payment details do
+// not include them.
+func (inv *Inv) parsePartialAdvancePayments(goblInv *bill.Invoice) error {
+	if len(inv.PartialAdvancePayments) == 0 {
+		return nil
+	}
+
+	if goblInv.Payment == nil {
+		goblInv.Payment = new(bill.PaymentDetails)
+	}
+	create := len(goblInv.Payment.Advances) == 0
+
+	for _, p := range inv.PartialAdvancePayments {
+		date, err := parseDate(p.PaymentDate)
+		if err != nil {
+			return fmt.Errorf("parsing partial advance date: %w", err)
+		}
+
+		if create {
+			amount, err := parseAmount(p.PaymentAmount)
+			if err != nil {
+				return fmt.Errorf("parsing partial advance amount: %w", err)
+			}
+			goblInv.Payment.Advances = append(goblInv.Payment.Advances, &pay.Advance{
+				Date:        &date,
+				Description: "Advance payment", // GOBL requires a description
+				Amount:      amount,
+			})
+		}
+
+		if p.CurrencyExchangeRate != "" {
+			rate, err := parseAmount(p.CurrencyExchangeRate)
+			if err != nil {
+				return fmt.Errorf("parsing partial advance exchange rate: %w", err)
+			}
+			goblInv.ExchangeRates = append(goblInv.ExchangeRates, &currency.ExchangeRate{
+				From:   goblInv.Currency,
+				To:     currency.PLN,
+				At:     cal.NewDateTime(date.Year, date.Month, date.Day, 0, 0, 0),
+				Amount: rate,
+			})
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,229 @@
+package ksef_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	ksef "github.com/invopop/gobl.ksef"
+	"github.com/invopop/gobl.ksef/test"
+	"github.com/invopop/gobl/addons/pl/favat"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/head"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/pay"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAdvanceInvoiceRefs(t *testing.T) {
+	t.Run("should return nil without preceding documents or advance references", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Payment: &bill.PaymentDetails{
+				Advances: []*pay.Advance{{Description: "Advance"}},
+			},
+		}
+		assert.Nil(t, ksef.NewAdvanceInvoiceRefs(inv))
+	})
+
+	t.Run("should use KSeF number when stamped", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Preceding: []*org.DocumentRef{
+				{
+					Series: "ZAL",
+					Code:   "001",
+					Stamps: []*head.Stamp{
+						{Provider: favat.StampKSEFNumber, Value: "8126178616-20260110-0100A0039876-5A"},
+					},
+				},
+			},
+		}
+
+		refs := ksef.NewAdvanceInvoiceRefs(inv)
+		require.Len(t, refs, 1)
+		assert.Equal(t, &ksef.AdvanceInvoiceRef{
+			KSeFAdvanceInvoiceNo: "8126178616-20260110-0100A0039876-5A",
+		}, refs[0])
+	})
+
+	t.Run("should use invoice number without KSeF number", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Preceding: []*org.DocumentRef{
+				{Series: "ZAL", Code: "002"},
+			},
+			Payment: &bill.PaymentDetails{
+				Advances: []*pay.Advance{{Ref: "ZAL/003", Description: "Advance"}},
+			},
+		}
+
+		refs := ksef.NewAdvanceInvoiceRefs(inv)
+		require.Len(t, refs, 2)
+		assert.Equal(t, &ksef.AdvanceInvoiceRef{KSeFMarker: 1, AdvanceInvoiceNo: "ZAL-002"}, refs[0])
+		assert.Equal(t, &ksef.AdvanceInvoiceRef{KSeFMarker: 1, AdvanceInvoiceNo: "ZAL/003"}, refs[1])
+	})
+
+	t.Run("should not repeat advances referring to a preceding document", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Preceding: []*org.DocumentRef{
+				{Series: "ZAL", Code: "002"},
+				{
+					Code: "8126178616-20260110-0100A0039876-5A",
+					Stamps: []*head.Stamp{
+						{Provider: favat.StampKSEFNumber, Value: "8126178616-20260110-0100A0039876-5A"},
+					},
+				},
+			},
+			Payment: &bill.PaymentDetails{
+				Advances: []*pay.Advance{
+					{Ref: "ZAL-002", Description: "Advance"},
+					{Ref: "8126178616-20260110-0100A0039876-5A", Description: "Advance"},
+				},
+			},
+		}
+
+		refs := ksef.NewAdvanceInvoiceRefs(inv)
+		require.Len(t, refs, 2)
+		assert.Equal(t, &ksef.AdvanceInvoiceRef{KSeFMarker: 1, AdvanceInvoiceNo: "ZAL-002"}, refs[0])
+		assert.Equal(t, &ksef.AdvanceInvoiceRef{KSeFAdvanceInvoiceNo: "8126178616-20260110-0100A0039876-5A"}, refs[1])
+	})
+}
+
+func TestParseAdvanceInvoices(t *testing.T) {
+	t.Run("should parse references into preceding documents", func(t *testing.T) {
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", "invoice-settlement-advances.xml"))
+		require.NoError(t, err)
+
+		var warnings []string
+		env, err := ksef.ParseKSeF(data, ksef.WithWarningHandler(func(w string) {
+			warnings = append(warnings, w)
+		}))
+		require.NoError(t, err)
+		inv, ok := env.Extract().(*bill.Invoice)
+		require.True(t, ok)
+
+		require.Len(t, inv.Preceding, 2)
+		prc := inv.Preceding[0]
+		assert.Equal(t, cbc.Code("8126178616-20260110-0100A0039876-5A"), prc.Code)
+		require.Len(t, prc.Stamps, 1)
+		assert.Equal(t, favat.StampKSEFNumber, prc.Stamps[0].Provider)
+
+		prc = inv.Preceding[1]
+		assert.Equal(t, cbc.Code("ZAL/2026/002"), prc.Code)
+		assert.Empty(t, prc.Stamps)
+
+		for _, prc := range inv.Preceding {
+			require.NotNil(t, prc.IssueDate)
+			assert.Equal(t, inv.IssueDate, *prc.IssueDate)
+		}
+		assert.Contains(t, warnings, "Fa>FakturaZaliczkowa: issue date of advance invoice ZAL/2026/002 unknown, set to "+inv.IssueDate.String())
+
+		for _, adv := range inv.Payment.Advances {
+			assert.Empty(t, adv.Ref)
+		}
+	})
+}
+
+func TestNewPartialAdvancePayments(t *testing.T) {
+	date1 := cal.MakeDate(2026, 1, 10)
+	date2 := cal.MakeDate(2026, 1, 17)
+	partialInvoice := func() *bill.Invoice {
+		inv := &bill.Invoice{
+			IssueDate: cal.MakeDate(2026, 1, 20),
+			Currency:  currency.EUR,
+			ExchangeRates: []*currency.ExchangeRate{
+				{From: currency.EUR, To: currency.PLN, Amount: num.MakeAmount(42000, 4)},
+				{From: currency.EUR, To: currency.PLN, At: cal.NewDateTime(2026, 1, 10, 0, 0, 0), Amount: num.MakeAmount(42310, 4)},
+			},
+			Payment: &bill.PaymentDetails{
+				Advances: []*pay.Advance{
+					{Date: &date1, Description: "First", Amount: num.MakeAmount(100000, 2)},
+					{Date: &date2, Description: "Second", Amount: num.MakeAmount(207500, 2)},
+				},
+			},
+		}
+		inv.SetTags(tax.TagPartial)
+		return inv
+	}
+
+	t.Run("should return nil for a single payment", func(t *testing.T) {
+		inv := partialInvoice()
+		inv.Payment.Advances = inv.Payment.Advances[:1]
+		assert.Nil(t, ksef.NewPartialAdvancePayments(inv))
+	})
+
+	t.Run("should return nil for invoices not tagged as partial", func(t *testing.T) {
+		inv := partialInvoice()
+		inv.Tags = tax.Tags{}
+		assert.Nil(t, ksef.NewPartialAdvancePayments(inv))
+	})
+
+	t.Run("should map each payment with its exchange rate", func(t *testing.T) {
+		payments := ksef.NewPartialAdvancePayments(partialInvoice())
+		require.Len(t, payments, 2)
+		assert.Equal(t, &ksef.PartialAdvancePayment{
+			PaymentDate:          "2026-01-10",
+			PaymentAmount:        "1000.00",
+			CurrencyExchangeRate: "4.2310",
+		}, payments[0])
+		assert.Equal(t, &ksef.PartialAdvancePayment{
+			PaymentDate:          "2026-01-17",
+			PaymentAmount:        "2075.00",
+			CurrencyExchangeRate: "4.2000",
+		}, payments[1])
+	})
+
+	t.Run("should not set exchange rates for PLN invoices", func(t *testing.T) {
+		inv := partialInvoice()
+		inv.Currency = currency.PLN
+		payments := ksef.NewPartialAdvancePayments(inv)
+		require.Len(t, payments, 2)
+		assert.Empty(t, payments[0].CurrencyExchangeRate)
+	})
+}
+
+func TestValidatePartialAdvancePayments(t *testing.T) {
+	t.Run("rejects more than 31 advance payments", func(t *testing.T) {
+		env, err := test.LoadTestEnvelope("invoice-prepayment-partial.json")
+		require.NoError(t, err)
+		inv := env.Extract().(*bill.Invoice)
+		for len(inv.Payment.Advances) <= 31 {
+			inv.Payment.Advances = append(inv.Payment.Advances, &pay.Advance{
+				Description: "Rata", Amount: num.MakeAmount(1000, 2),
+			})
+		}
+
+		_, err = ksef.BuildFavat(env)
+		assert.ErrorContains(t, err, "32 advance payments, maximum is 31")
+	})
+}
+
+func TestParsePartialAdvancePayments(t *testing.T) {
+	t.Run("should parse partial payments into advances and exchange rates", func(t *testing.T) {
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", "invoice-prepayment-partial.xml"))
+		require.NoError(t, err)
+
+		env, err := ksef.ParseKSeF(data)
+		require.NoError(t, err)
+		inv, ok := env.Extract().(*bill.Invoice)
+		require.True(t, ok)
+
+		require.NotNil(t, inv.Payment)
+		require.Len(t, inv.Payment.Advances, 2)
+		assert.Equal(t, "2026-01-17", inv.Payment.Advances[1].Date.String())
+		assert.Equal(t, "2075.00", inv.Payment.Advances[1].Amount.String())
+
+		require.Len(t, inv.ExchangeRates, 3)
+		assert.Nil(t, inv.ExchangeRates[0].At)
+		rate := inv.ExchangeRates[2]
+		assert.Equal(t, currency.EUR, rate.From)
+		assert.Equal(t, currency.PLN, rate.To)
+		require.NotNil(t, rate.At)
+		assert.Equal(t, "2026-01-17", rate.At.Date().String())
+		assert.Equal(t, "4.2150", rate.Amount.String())
+	})
+}
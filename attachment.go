@@ -0,0 +1,199 @@
+package ksef
+
+import (
+	"fmt"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/schema"
+)
+
+// emptyCell is the alternative encoding of missing table cell values
+const emptyCell = "-"
+
+// SchemaBase is the base of the IDs of the GOBL schemas defined by this
+// module, like the FA(3) attachment complement:
+//
+//	https://github.com/invopop/gobl.ksef/fa3/attachment
+const SchemaBase schema.ID = "https://github.com/invopop/gobl.ksef/fa3"
+
+func init() {
+	schema.Register(SchemaBase, Attachment{})
+}
+
+// Attachment defines the XML structure for KSeF attachment (Zalacznik). It is
+// also registered as a GOBL complement, so it can be included in the invoice
+// complements and converted as is.
+type Attachment struct {
+	Blocks []*DataBlock `xml:"BlokDanych" json:"blocks"` // up to 1000
+}
+
+// DataBlock defines the XML structure for an attachment data block
+type DataBlock struct {
+	Header string       `xml:"ZNaglowek,omitempty" json:"header,omitempty"`
+	Meta   []*BlockMeta `xml:"MetaDane" json:"meta"`
+	Text   []string     `xml:"Tekst>Akapit,omitempty" json:"text,omitempty"` // up to 10 paragraphs
+	Tables []*Table     `xml:"Tabela,omitempty" json:"tables,omitempty"`
+}
+
+// BlockMeta defines the XML structure for a data block key and value
+type BlockMeta struct {
+	Key   string `xml:"ZKlucz" json:"key"`
+	Value string `xml:"ZWartosc" json:"value"`
+}
+
+// Table defines the XML structure for an attachment table
+type Table struct {
+	Meta        []*TableMeta   `xml:"TMetaDane,omitempty" json:"meta,omitempty"`
+	Description string         `xml:"Opis,omitempty" json:"description,omitempty"`
+	Columns     []*TableColumn `xml:"TNaglowek>Kol" json:"columns"` // up to 20
+	Rows        []*TableRow    `xml:"Wiersz" json:"rows"`
+	Summary     *TableSummary  `xml:"Suma,omitempty" json:"summary,omitempty"`
+}
+
+// TableMeta defines the XML structure for a table key and value
+type TableMeta struct {
+	Key   string `xml:"TKlucz" json:"key"`
+	Value string `xml:"TWartosc" json:"value"`
+}
+
+// TableColumn defines the XML structure for a table column header. The type is
+// one of date, datetime, dec, int, time or txt.
+type TableColumn struct {
+	Type string `xml:"Typ,attr" json:"type"`
+	Name string `xml:"NKom" json:"name"`
+}
+
+// TableRow defines the XML structure for a table row. Missing values are left
+// empty.
+type TableRow struct {
+	Cells []string `xml:"WKom" json:"cells"`
+}
+
+// TableSummary defines the XML structure for the table summary row
+type TableSummary struct {
+	Cells []string `xml:"SKom" json:"cells"`
+}
+
+// Limits of the attachment allowed by the schema
+const (
+	maxDataBlocks     = 1000
+	maxBlockParagraph = 10
+	maxTables         = 1000
+	maxTableColumns   = 20
+	maxTableRows      = 1000
+)
+
+// Validate checks that every data block has at least one key and value
+// (MetaDane), as required by KSeF, and that the blocks, paragraphs, tables,
+// columns and rows are within the schema limits, with a cell per column in
+// every row and summary.
+func (a *Attachment) Validate() error {
+	if n := len(a.Blocks); n > maxDataBlocks {
+		return fmt.Errorf("%d data blocks, maximum is %d", n, maxDataBlocks)
+	}
+	for i, block := range a.Blocks {
+		if len(block.Meta) == 0 {
+			return fmt.Errorf("data block %d: missing meta data", i+1)
+		}
+		if n := len(block.Text); n > maxBlockParagraph {
+			return fmt.Errorf("data block %d: %d paragraphs, maximum is %d", i+1, n, maxBlockParagraph)
+		}
+		if n := len(block.Tables); n > maxTables {
+			return fmt.Errorf("data block %d: %d tables, maximum is %d", i+1, n, maxTables)
+		}
+		for j, table := range block.Tables {
+			if err := table.validate(); err != nil {
+				return fmt.Errorf("data block %d: table %d: %w", i+1, j+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Table) validate() error {
+	columns := len(t.Columns)
+	if columns > maxTableColumns {
+		return fmt.Errorf("%d columns, maximum is %d", columns, maxTableColumns)
+	}
+	if n := len(t.Rows); n > maxTableRows {
+		return fmt.Errorf("%d rows, maximum is %d", n, maxTableRows)
+	}
+	for i, row := range t.Rows {
+		if n := len(row.Cells); n != columns {
+			return fmt.Errorf("row %d: %d cells for %d columns", i+1, n, columns)
+		}
+	}
+	if t.Summary != nil {
+		if n := len(t.Summary.Cells); n != columns {
+			return fmt.Errorf("summary: %d cells for %d columns", n, columns)
+		}
+	}
+	return nil
+}
+
+// NewAttachment returns the attachment included in the invoice complements,
+// if any.
+func NewAttachment(inv *bill.Invoice) (*Attachment, error) {
+	for _, c := range inv.Complements {
+		if a, ok := c.Instance().(*Attachment); ok {
+			if err := a.Validate(); err != nil {
+				return nil, fmt.Errorf("attachment: %w", err)
+			}
+			return a, nil
+		}
+	}
+	return nil, nil
+}
+
+// parseAttachment adds the KSeF attachment to the GOBL invoice complements.
+// Missing table cells, encoded either as an empty element or as a dash, are
+// always left empty.
+func (d *Invoice) parseAttachment(inv *bill.Invoice) error {
+	if d.Attachment == nil {
+		return nil
+	}
+	if err := d.Attachment.Validate(); err != nil {
+		return fmt.Errorf("parsing attachment: %w", err)
+	}
+
+	obj, err := schema.NewObject(d.Attachment.normalized())
+	if err != nil {
+		return fmt.Errorf("creating attachment complement: %w", err)
+	}
+	inv.Complements = append(inv.Complements, obj)
+
+	return nil
+}
+
+// normalized returns a copy of the attachment with the missing table cells
+// left empty, keeping the cells of the attachment as received.
+func (a *Attachment) normalized() *Attachment {
+	na := &Attachment{Blocks: make([]*DataBlock, len(a.Blocks))}
+	for i, block := range a.Blocks {
+		nb := *block
+		nb.Tables = make([]*Table, len(block.Tables))
+		for j, table := range block.Tables {
+			nt := *table
+			nt.Rows = make([]*TableRow, len(table.Rows))
+			for k, row := range table.Rows {
+				nt.Rows[k] = &TableRow{Cells: normalizeCells(row.Cells)}
+			}
+			if table.Summary != nil {
+				nt.Summary = &TableSummary{Cells: normalizeCells(table.Summary.Cells)}
+			}
+			nb.Tables[j] = &nt
+		}
+		na.Blocks[i] = &nb
+	}
+	return na
+}
+
+func normalizeCells(cells []string) []string {
+	result := make([]string, len(cells))
+	for i, c := range cells {
+		if c != emptyCell {
+			result[i] = c
+		}
+	}
+	return result
+}
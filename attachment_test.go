@@ -0,0 +1,175 @@
+package ksef_test
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	ksef "github.com/invopop/gobl.ksef"
+	"github.com/invopop/gobl.ksef/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAttachment(t *testing.T) {
+	t.Run("should return nil without attachment complement", func(t *testing.T) {
+		att, err := ksef.NewAttachment(&bill.Invoice{})
+		require.NoError(t, err)
+		assert.Nil(t, att)
+	})
+
+	t.Run("should return attachment from complements", func(t *testing.T) {
+		att := &ksef.Attachment{
+			Blocks: []*ksef.DataBlock{
+				{Meta: []*ksef.BlockMeta{{Key: "Licznik", Value: "L-001"}}},
+			},
+		}
+		obj, err := schema.NewObject(att)
+		require.NoError(t, err)
+
+		assert.Equal(t, schema.ID("https://github.com/invopop/gobl.ksef/fa3/attachment"), obj.Schema)
+
+		inv := &bill.Invoice{Complements: []*schema.Object{obj}}
+		got, err := ksef.NewAttachment(inv)
+		require.NoError(t, err)
+		assert.Equal(t, att, got)
+	})
+
+	t.Run("should reject data blocks without meta data", func(t *testing.T) {
+		att := &ksef.Attachment{
+			Blocks: []*ksef.DataBlock{
+				{Meta: []*ksef.BlockMeta{{Key: "Licznik", Value: "L-001"}}},
+				{Header: "Uwagi", Text: []string{"Bez metadanych"}},
+			},
+		}
+		obj, err := schema.NewObject(att)
+		require.NoError(t, err)
+
+		inv := &bill.Invoice{Complements: []*schema.Object{obj}}
+		_, err = ksef.NewAttachment(inv)
+		assert.EqualError(t, err, "attachment: data block 2: missing meta data")
+	})
+
+	for _, tt := range []struct {
+		name  string
+		block *ksef.DataBlock
+		err   string
+	}{
+		{
+			name: "should reject more than 10 paragraphs",
+			block: &ksef.DataBlock{
+				Text: []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11"},
+			},
+			err: "attachment: data block 1: 11 paragraphs, maximum is 10",
+		},
+		{
+			name: "should reject more than 20 columns",
+			block: &ksef.DataBlock{
+				Tables: []*ksef.Table{{Columns: make([]*ksef.TableColumn, 21)}},
+			},
+			err: "attachment: data block 1: table 1: 21 columns, maximum is 20",
+		},
+		{
+			name: "should reject rows without a cell per column",
+			block: &ksef.DataBlock{
+				Tables: []*ksef.Table{{
+					Columns: []*ksef.TableColumn{{Type: "txt", Name: "Numer"}, {Type: "int", Name: "Czas"}},
+					Rows:    []*ksef.TableRow{{Cells: []string{"601 100 200", "60"}}, {Cells: []string{"601 100 201"}}},
+				}},
+			},
+			err: "attachment: data block 1: table 1: row 2: 1 cells for 2 columns",
+		},
+		{
+			name: "should reject summaries without a cell per column",
+			block: &ksef.DataBlock{
+				Tables: []*ksef.Table{{
+					Columns: []*ksef.TableColumn{{Type: "txt", Name: "Numer"}, {Type: "int", Name: "Czas"}},
+					Rows:    []*ksef.TableRow{{Cells: []string{"601 100 200", "60"}}},
+					Summary: &ksef.TableSummary{Cells: []string{"60"}},
+				}},
+			},
+			err: "attachment: data block 1: table 1: summary: 1 cells for 2 columns",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.block.Meta = []*ksef.BlockMeta{{Key: "Licznik", Value: "L-001"}}
+			obj, err := schema.NewObject(&ksef.Attachment{Blocks: []*ksef.DataBlock{tt.block}})
+			require.NoError(t, err)
+
+			inv := &bill.Invoice{Complements: []*schema.Object{obj}}
+			_, err = ksef.NewAttachment(inv)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+
+	t.Run("should reject more than 1000 data blocks", func(t *testing.T) {
+		att := &ksef.Attachment{}
+		for i := 0; i < 1001; i++ {
+			att.Blocks = append(att.Blocks, &ksef.DataBlock{Meta: []*ksef.BlockMeta{{Key: "Licznik", Value: "L-001"}}})
+		}
+		obj, err := schema.NewObject(att)
+		require.NoError(t, err)
+
+		inv := &bill.Invoice{Complements: []*schema.Object{obj}}
+		_, err = ksef.NewAttachment(inv)
+		assert.EqualError(t, err, "attachment: 1001 data blocks, maximum is 1000")
+	})
+}
+
+func TestParseAttachment(t *testing.T) {
+	t.Run("should parse attachment into complement with empty missing cells", func(t *testing.T) {
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", "invoice-attachment.xml"))
+		require.NoError(t, err)
+
+		env, err := ksef.ParseKSeF(data)
+		require.NoError(t, err)
+		inv, ok := env.Extract().(*bill.Invoice)
+		require.True(t, ok)
+
+		require.Len(t, inv.Complements, 1)
+		att, ok := inv.Complements[0].Instance().(*ksef.Attachment)
+		require.True(t, ok)
+
+		require.Len(t, att.Blocks, 1)
+		block := att.Blocks[0]
+		assert.Equal(t, "Zestawienie połączeń", block.Header)
+		assert.Len(t, block.Text, 2)
+		require.Len(t, block.Tables, 1)
+
+		table := block.Tables[0]
+		require.Len(t, table.Columns, 4)
+		assert.Equal(t, "datetime", table.Columns[0].Type)
+		require.Len(t, table.Rows, 2)
+		assert.Equal(t, []string{"2026-01-07T18:40:00", "", "60", ""}, table.Rows[1].Cells)
+		require.NotNil(t, table.Summary)
+		assert.Equal(t, []string{"", "", "180", "0.50"}, table.Summary.Cells)
+	})
+
+	t.Run("should keep the cells of the parsed document as received", func(t *testing.T) {
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", "invoice-attachment.xml"))
+		require.NoError(t, err)
+
+		doc := new(ksef.Invoice)
+		require.NoError(t, xml.Unmarshal(data, doc))
+		_, err = doc.ToGOBL()
+		require.NoError(t, err)
+
+		out, err := doc.Bytes()
+		require.NoError(t, err)
+		assert.Contains(t, string(out), "<WKom>-</WKom>")
+		assert.Contains(t, string(out), "<SKom>-</SKom>")
+	})
+
+	t.Run("should reject data blocks without meta data", func(t *testing.T) {
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", "invoice-attachment.xml"))
+		require.NoError(t, err)
+		xml := regexp.MustCompile(`(?s)<MetaDane>.*?</MetaDane>\s*`).ReplaceAllString(string(data), "")
+
+		_, err = ksef.ParseKSeF([]byte(xml))
+		assert.ErrorContains(t, err, "parsing attachment: data block 1: missing meta data")
+	})
+}
@@ -0,0 +1,219 @@
+package ksef
+
+import (
+	"fmt"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
+)
+
+// Keys used to store transaction conditions that have no dedicated field in GOBL.
+const (
+	// IdentityKeyBatch identifies the ordering identities that hold product
+	// batch numbers (NrPartiiTowaru).
+	IdentityKeyBatch cbc.Key = "batch"
+	// MetaKeyDeliveryTerms is the delivery details meta key holding the delivery
+	// terms (WarunkiDostawy), usually an Incoterms rule and place.
+	MetaKeyDeliveryTerms cbc.Key = "delivery-terms"
+	// ExchangeRateSourceContract is the source of the exchange rate agreed in the
+	// contract (KursUmowny).
+	ExchangeRateSourceContract cbc.Key = "contract"
+)
+
+// TransactionConditions defines the XML structure for transaction conditions
+type TransactionConditions struct {
+	Contracts         []*Contract  `xml:"Umowy,omitempty"`          // up to 100
+	Orders            []*OrderRef  `xml:"Zamowienia,omitempty"`     // up to 100
+	BatchNumbers      []string     `xml:"NrPartiiTowaru,omitempty"` // up to 1000
+	DeliveryTerms     string       `xml:"WarunkiDostawy,omitempty"`
+	ContractRate      string       `xml:"KursUmowny,omitempty"`
+	ContractCurrency  string       `xml:"WalutaUmowna,omitempty"`
+	Transport         []*Transport `xml:"Transport,omitempty"`
+	IntermediaryParty int          `xml:"PodmiotPosredniczacy,omitempty"`
+}
+
+// Contract defines the XML structure for contract reference
+type Contract struct {
+	Date   string `xml:"DataUmowy,omitempty"`
+	Number string `xml:"NrUmowy,omitempty"`
+}
+
+// OrderRef defines the XML structure for order reference
+type OrderRef struct {
+	Date   string `xml:"DataZamowienia,omitempty"`
+	Number string `xml:"NrZamowienia,omitempty"`
+}
+
+// Maximum numbers of contracts, orders and batch numbers allowed by the schema
+const (
+	maxContracts    = 100
+	maxOrders       = 100
+	maxBatchNumbers = 1000
+)
+
+// validateTransactionConditions checks that the contracts, purchase orders and
+// batch numbers of the invoice ordering details do not exceed the schema
+// limits.
+func validateTransactionConditions(inv *bill.Invoice) error {
+	if inv.Ordering == nil {
+		return nil
+	}
+	if n := len(inv.Ordering.Contracts); n > maxContracts {
+		return fmt.Errorf("%d ordering contracts, maximum is %d", n, maxContracts)
+	}
+	if n := len(inv.Ordering.Purchases); n > maxOrders {
+		return fmt.Errorf("%d ordering purchases, maximum is %d", n, maxOrders)
+	}
+	var batches int
+	for _, id := range inv.Ordering.Identities {
+		if id.Key == IdentityKeyBatch {
+			batches++
+		}
+	}
+	if batches > maxBatchNumbers {
+		return fmt.Errorf("%d batch identities, maximum is %d", batches, maxBatchNumbers)
+	}
+	return nil
+}
+
+// NewTransactionConditions builds the transaction conditions from the contracts,
+// purchase orders and batch identities of the invoice ordering details, the
+// delivery terms and transport details, and the contractual exchange rate.
+// Returns nil when none of them are present.
+func NewTransactionConditions(inv *bill.Invoice) *TransactionConditions {
+	tc := new(TransactionConditions)
+
+	if inv.Ordering != nil {
+		for _, ref := range inv.Ordering.Contracts {
+			date, number := documentRefData(ref)
+			tc.Contracts = append(tc.Contracts, &Contract{Date: date, Number: number})
+		}
+		for _, ref := range inv.Ordering.Purchases {
+			date, number := documentRefData(ref)
+			tc.Orders = append(tc.Orders, &OrderRef{Date: date, Number: number})
+		}
+		for _, id := range inv.Ordering.Identities {
+			if id.Key == IdentityKeyBatch {
+				tc.BatchNumbers = append(tc.BatchNumbers, id.Code.String())
+			}
+		}
+	}
+
+	if inv.Delivery != nil && inv.Delivery.Meta != nil {
+		tc.DeliveryTerms = (*inv.Delivery.Meta)[MetaKeyDeliveryTerms]
+	}
+
+	if rate := contractExchangeRate(inv.ExchangeRates); rate != nil {
+		tc.ContractRate = rate.Amount.String()
+		tc.ContractCurrency = rate.From.String()
+	}
+
+	if t := NewTransport(inv.Delivery); t != nil {
+		tc.Transport = []*Transport{t}
+	}
+
+	if len(tc.Contracts) == 0 && len(tc.Orders) == 0 && len(tc.BatchNumbers) == 0 &&
+		tc.DeliveryTerms == "" && tc.ContractRate == "" && len(tc.Transport) == 0 {
+		return nil
+	}
+
+	return tc
+}
+
+func documentRefData(ref *org.DocumentRef) (string, string) {
+	var date string
+	if ref.IssueDate != nil {
+		date = ref.IssueDate.String()
+	}
+	return date, invoiceNumber(ref.Series, ref.Code)
+}
+
+func contractExchangeRate(rates []*currency.ExchangeRate) *currency.ExchangeRate {
+	for _, rate := range rates {
+		if rate.Source == ExchangeRateSourceContract {
+			return rate
+		}
+	}
+	return nil
+}
+
+// parseTransactionConditions converts the KSeF transaction conditions into
+// GOBL ordering details, delivery meta and a contract exchange rate.
+func (inv *Inv) parseTransactionConditions(goblInv *bill.Invoice) error {
+	tc := inv.TransactionConditions
+	if tc == nil {
+		return nil
+	}
+
+	ordering := goblInv.Ordering
+	if ordering == nil {
+		ordering = new(bill.Ordering)
+	}
+
+	for _, c := range tc.Contracts {
+		ref, err := parseDocumentRef(c.Date, c.Number)
+		if err != nil {
+			return fmt.Errorf("parsing contract: %w", err)
+		}
+		ordering.Contracts = append(ordering.Contracts, ref)
+	}
+	for _, o := range tc.Orders {
+		ref, err := parseDocumentRef(o.Date, o.Number)
+		if err != nil {
+			return fmt.Errorf("parsing order: %w", err)
+		}
+		ordering.Purchases = append(ordering.Purchases, ref)
+	}
+	for _, batch := range tc.BatchNumbers {
+		ordering.Identities = append(ordering.Identities, &org.Identity{
+			Key:  IdentityKeyBatch,
+			Code: cbc.Code(batch),
+		})
+	}
+
+	if goblInv.Ordering == nil && (len(ordering.Contracts) > 0 || len(ordering.Purchases) > 0 || len(ordering.Identities) > 0) {
+		goblInv.Ordering = ordering
+	}
+
+	if tc.DeliveryTerms != "" {
+		if goblInv.Delivery == nil {
+			goblInv.Delivery = new(bill.DeliveryDetails)
+		}
+		if goblInv.Delivery.Meta == nil {
+			goblInv.Delivery.Meta = &cbc.Meta{}
+		}
+		(*goblInv.Delivery.Meta)[MetaKeyDeliveryTerms] = tc.DeliveryTerms
+	}
+
+	if tc.ContractRate != "" {
+		rate, err := num.AmountFromString(tc.ContractRate)
+		if err != nil {
+			return fmt.Errorf("parsing contract exchange rate: %w", err)
+		}
+		goblInv.ExchangeRates = append(goblInv.ExchangeRates, &currency.ExchangeRate{
+			From:   currency.Code(tc.ContractCurrency),
+			To:     currency.Code(parseCurrency(inv.CurrencyCode)),
+			Source: ExchangeRateSourceContract,
+			Amount: rate,
+		})
+	}
+
+	return nil
+}
+
+func parseDocumentRef(date, number string) (*org.DocumentRef, error) {
+	ref := &org.DocumentRef{
+		Code: cbc.Code(number),
+	}
+	if date != "" {
+		d, err := parseDate(date)
+		if err != nil {
+			return nil, fmt.Errorf("parsing date: %w", err)
+		}
+		ref.IssueDate = &d
+	}
+	return ref, nil
+}
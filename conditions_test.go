@@ -0,0 +1,138 @@
+package ksef_test
+
+import (
+	"testing"
+
+	"github.com/invopop/gobl"
+	ksef "github.com/invopop/gobl.ksef"
+	"github.com/invopop/gobl.ksef/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTransactionConditions(t *testing.T) {
+	t.Run("should return nil when there are no conditions", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Ordering: &bill.Ordering{
+				Period: &cal.Period{
+					Start: cal.MakeDate(2026, 1, 1),
+					End:   cal.MakeDate(2026, 1, 31),
+				},
+			},
+		}
+		assert.Nil(t, ksef.NewTransactionConditions(inv))
+	})
+
+	t.Run("should map contracts, orders and batch numbers", func(t *testing.T) {
+		date := cal.MakeDate(2025, 11, 3)
+		inv := &bill.Invoice{
+			Ordering: &bill.Ordering{
+				Identities: []*org.Identity{
+					{Key: ksef.IdentityKeyBatch, Code: "P-0117"},
+					{Key: org.IdentityKeyOrder, Code: "IGNORED"},
+				},
+				Contracts: []*org.DocumentRef{
+					{IssueDate: &date, Series: "UR", Code: "114"},
+				},
+				Purchases: []*org.DocumentRef{
+					{Code: "ZAM-4471"},
+				},
+			},
+		}
+
+		tc := ksef.NewTransactionConditions(inv)
+		require.NotNil(t, tc)
+		assert.Equal(t, []*ksef.Contract{{Date: "2025-11-03", Number: "UR-114"}}, tc.Contracts)
+		assert.Equal(t, []*ksef.OrderRef{{Number: "ZAM-4471"}}, tc.Orders)
+		assert.Equal(t, []string{"P-0117"}, tc.BatchNumbers)
+	})
+
+	t.Run("should map delivery terms and contract exchange rate", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Currency: currency.PLN,
+			Delivery: &bill.DeliveryDetails{
+				Meta: &cbc.Meta{ksef.MetaKeyDeliveryTerms: "FCA Poznań"},
+			},
+			ExchangeRates: []*currency.ExchangeRate{
+				{From: currency.USD, To: currency.PLN, Amount: num.MakeAmount(39000, 4)},
+				{From: currency.EUR, To: currency.PLN, Source: ksef.ExchangeRateSourceContract, Amount: num.MakeAmount(42500, 4)},
+			},
+		}
+
+		tc := ksef.NewTransactionConditions(inv)
+		require.NotNil(t, tc)
+		assert.Equal(t, "FCA Poznań", tc.DeliveryTerms)
+		assert.Equal(t, "4.2500", tc.ContractRate)
+		assert.Equal(t, "EUR", tc.ContractCurrency)
+	})
+}
+
+func TestValidateTransactionConditions(t *testing.T) {
+	load := func(t *testing.T) (*gobl.Envelope, *bill.Invoice) {
+		t.Helper()
+		env, err := test.LoadTestEnvelope("invoice-standard.json")
+		require.NoError(t, err)
+		inv := env.Extract().(*bill.Invoice)
+		if inv.Ordering == nil {
+			inv.Ordering = new(bill.Ordering)
+		}
+		return env, inv
+	}
+
+	t.Run("rejects more than 100 contracts", func(t *testing.T) {
+		env, inv := load(t)
+		for i := 0; i < 101; i++ {
+			inv.Ordering.Contracts = append(inv.Ordering.Contracts, &org.DocumentRef{Code: "UM-1"})
+		}
+
+		_, err := ksef.BuildFavat(env)
+		assert.ErrorContains(t, err, "101 ordering contracts, maximum is 100")
+	})
+
+	t.Run("rejects more than 100 purchase orders", func(t *testing.T) {
+		env, inv := load(t)
+		for i := 0; i < 101; i++ {
+			inv.Ordering.Purchases = append(inv.Ordering.Purchases, &org.DocumentRef{Code: "ZAM-1"})
+		}
+
+		_, err := ksef.BuildFavat(env)
+		assert.ErrorContains(t, err, "101 ordering purchases, maximum is 100")
+	})
+}
+
+func TestParseTransactionConditions(t *testing.T) {
+	t.Run("should parse transaction conditions into ordering", func(t *testing.T) {
+		data, err := test.LoadOutputFile("invoice-conditions.xml")
+		require.NoError(t, err)
+
+		env, err := ksef.ParseKSeF(data)
+		require.NoError(t, err)
+		inv, ok := env.Extract().(*bill.Invoice)
+		require.True(t, ok)
+
+		require.NotNil(t, inv.Ordering)
+		require.Len(t, inv.Ordering.Contracts, 1)
+		assert.Equal(t, cbc.Code("UR/2025/114"), inv.Ordering.Contracts[0].Code)
+		assert.Equal(t, "2025-11-03", inv.Ordering.Contracts[0].IssueDate.String())
+		require.Len(t, inv.Ordering.Purchases, 2)
+		assert.Equal(t, cbc.Code("ZAM-4502"), inv.Ordering.Purchases[1].Code)
+		require.Len(t, inv.Ordering.Identities, 2)
+		assert.Equal(t, ksef.IdentityKeyBatch, inv.Ordering.Identities[0].Key)
+		assert.Equal(t, cbc.Code("P-2026-0117"), inv.Ordering.Identities[0].Code)
+
+		require.NotNil(t, inv.Delivery)
+		assert.Equal(t, "DAP Gdańsk, Incoterms 2020", (*inv.Delivery.Meta)[ksef.MetaKeyDeliveryTerms])
+
+		require.Len(t, inv.ExchangeRates, 1)
+		assert.Equal(t, currency.EUR, inv.ExchangeRates[0].From)
+		assert.Equal(t, currency.PLN, inv.ExchangeRates[0].To)
+		assert.Equal(t, ksef.ExchangeRateSourceContract, inv.ExchangeRates[0].Source)
+		assert.Equal(t, "4.2500", inv.ExchangeRates[0].Amount.String())
+	})
+}
@@ -1,23 +1,64 @@
 package ksef
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/invopop/gobl/addons/pl/favat"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
 	"github.com/invopop/gobl/cbc"
 	"github.com/invopop/gobl/head"
+	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/num"
 	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/tax"
+)
+
+// Identity keys of the preceding document with the party data before the
+// correction, with the tax code in the identity code, the name in the label
+// and the address in the description.
+const (
+	IdentityKeySupplier cbc.Key = "supplier"
+	IdentityKeyCustomer cbc.Key = "customer"
 )
 
+// MetaKeyCorrectionPeriod holds the period of the corrected invoices in the
+// preceding document meta, when it is not a date range.
+const MetaKeyCorrectionPeriod cbc.Key = "correction-period"
+
+// MetaKeyCorrectedNumber holds the correct number of the preceding document,
+// when the reason for the correction is a wrong invoice number.
+const MetaKeyCorrectedNumber cbc.Key = "corrected-number"
+
+// buyerLinkID is the key linking the buyer with its data before correction
+const buyerLinkID = "1"
+
 // CorrectedInv defines the XML structure for KSeF correction invoice
 type CorrectedInv struct {
-	IssueDate           string   `xml:"DataWystFaKorygowanej,omitempty"`
-	SequentialNumber    string   `xml:"NrFaKorygowanej,omitempty"`
-	CorrectionPeriod    string   `xml:"OkresFaKorygowanej,omitempty"`
-	CorrectedInvoiceNo  string   `xml:"NrFaKorygowany,omitempty"`
-	KsefNumberPresent   int      `xml:"NrKSeF,omitempty"`
-	NoKsefNumberPresent int      `xml:"NrKSeFN,omitempty"`
-	KsefNumber          string   `xml:"NrKSeFFaKorygowanej,omitempty"`
-	CorrectedSeller     *Seller  `xml:"Podmiot1K,omitempty"`
-	CorrectedBuyer      []*Buyer `xml:"Podmiot2K,omitempty"`
+	IssueDate           string `xml:"DataWystFaKorygowanej,omitempty"`
+	SequentialNumber    string `xml:"NrFaKorygowanej,omitempty"`
+	KsefNumberPresent   int    `xml:"NrKSeF,omitempty"`
+	NoKsefNumberPresent int    `xml:"NrKSeFN,omitempty"`
+	KsefNumber          string `xml:"NrKSeFFaKorygowanej,omitempty"`
+}
+
+// CorrectedBuyer defines the XML structure for the buyer data before
+// correction (Podmiot2K)
+type CorrectedBuyer struct {
+	NIP string `xml:"DaneIdentyfikacyjne>NIP,omitempty"`
+	// or
+	UECode      string `xml:"DaneIdentyfikacyjne>KodUE,omitempty"`
+	UEVatNumber string `xml:"DaneIdentyfikacyjne>NrVatUE,omitempty"`
+	// or
+	CountryCode string `xml:"DaneIdentyfikacyjne>KodKraju,omitempty"`
+	IDNumber    string `xml:"DaneIdentyfikacyjne>NrID,omitempty"`
+	// or
+	NoID int `xml:"DaneIdentyfikacyjne>BrakID,omitempty"`
+
+	Name    string   `xml:"DaneIdentyfikacyjne>Nazwa,omitempty"`
+	Address *Address `xml:"Adres,omitempty"`
+	BuyerID string   `xml:"IDNabywcy,omitempty"`
 }
 
 // NewCorrectedInv gets credit note data from GOBL invoice
@@ -40,6 +81,29 @@ func NewCorrectedInv(prc *org.DocumentRef) *CorrectedInv {
 	return inv
 }
 
+// newCorrectedInvoiceNo returns the correct number of the first preceding
+// document with a wrong number.
+func newCorrectedInvoiceNo(preceding []*org.DocumentRef) string {
+	for _, prc := range preceding {
+		if n := prc.Meta[MetaKeyCorrectedNumber]; n != "" {
+			return n
+		}
+	}
+	return ""
+}
+
+// newAmountBeforeCorrection returns the amount paid on a corrected advance
+// invoice, or the amount left to pay on other corrected invoices, from the
+// payable amount of the first preceding document with one.
+func newAmountBeforeCorrection(preceding []*org.DocumentRef) string {
+	for _, prc := range preceding {
+		if prc.Payable != nil {
+			return prc.Payable.String()
+		}
+	}
+	return ""
+}
+
 func findStamp(a []*head.Stamp, x cbc.Key) int {
 	for i, n := range a {
 		if x == n.Provider {
@@ -48,3 +112,314 @@ func findStamp(a []*head.Stamp, x cbc.Key) int {
 	}
 	return -1
 }
+
+// NewCorrectedSeller builds the seller data before correction from the
+// supplier identity of the first preceding document. The current supplier
+// address is used when the identity does not include one.
+func NewCorrectedSeller(inv *bill.Invoice) *Seller {
+	id := precedingPartyIdentity(inv, IdentityKeySupplier)
+	if id == nil {
+		return nil
+	}
+
+	seller := &Seller{
+		NIP:  id.Code.String(),
+		Name: id.Label,
+	}
+	if id.Country != "" {
+		seller.VATPrefix = id.Country.String()
+	}
+	if id.Description != "" {
+		seller.Address = &Address{
+			CountryCode: partyCountry(id).String(),
+			AddressL1:   id.Description,
+		}
+	} else if inv.Supplier != nil && len(inv.Supplier.Addresses) > 0 {
+		seller.Address = newAddress(inv.Supplier.Addresses[0])
+	}
+
+	return seller
+}
+
+// NewCorrectedBuyers builds the buyer data before correction from the
+// customer identity of the first preceding document.
+func NewCorrectedBuyers(inv *bill.Invoice) []*CorrectedBuyer {
+	id := precedingPartyIdentity(inv, IdentityKeyCustomer)
+	if id == nil {
+		return nil
+	}
+
+	buyer := &CorrectedBuyer{
+		Name:    id.Label,
+		BuyerID: buyerLinkID,
+	}
+	country := partyCountry(id)
+	switch {
+	case id.Code == "":
+		buyer.NoID = 1
+	case country == l10n.PL.ISO():
+		buyer.NIP = id.Code.String()
+	case l10n.Union(l10n.EU).HasMember(country.Code()):
+		buyer.UECode = country.String()
+		buyer.UEVatNumber = id.Code.String()
+	default:
+		buyer.CountryCode = country.String()
+		buyer.IDNumber = id.Code.String()
+	}
+	if id.Description != "" {
+		buyer.Address = &Address{
+			CountryCode: country.String(),
+			AddressL1:   id.Description,
+		}
+	}
+
+	return []*CorrectedBuyer{buyer}
+}
+
+func precedingPartyIdentity(inv *bill.Invoice, key cbc.Key) *org.Identity {
+	if len(inv.Preceding) == 0 {
+		return nil
+	}
+	for _, id := range inv.Preceding[0].Identities {
+		if id.Key == key {
+			return id
+		}
+	}
+	return nil
+}
+
+func partyCountry(id *org.Identity) l10n.ISOCountryCode {
+	if id.Country == "" {
+		return l10n.PL.ISO()
+	}
+	return id.Country
+}
+
+// parseCorrectedParties adds the party data before correction to the first
+// preceding document. Corrections of party data or of the invoice number only
+// have no lines in KSeF, so a line with no quantity, marked as a placeholder,
+// is added as GOBL requires at least one.
+func (d *Invoice) parseCorrectedParties(goblInv *bill.Invoice) {
+	inv := d.Inv
+	if inv.CorrectedSeller == nil && len(inv.CorrectedBuyers) == 0 && inv.CorrectedInvoiceNo == "" {
+		return
+	}
+	if len(goblInv.Preceding) == 0 {
+		return
+	}
+	prc := goblInv.Preceding[0]
+
+	if s := inv.CorrectedSeller; s != nil {
+		id := &org.Identity{
+			Key:     IdentityKeySupplier,
+			Country: l10n.ISOCountryCode(s.VATPrefix),
+			Code:    cbc.Code(s.NIP),
+			Label:   s.Name,
+		}
+		if s.Address != nil {
+			id.Description = addressDescription(s.Address)
+		}
+		prc.Identities = append(prc.Identities, id)
+	}
+
+	if b := d.correctedBuyer(); b != nil {
+		id := &org.Identity{
+			Key:   IdentityKeyCustomer,
+			Label: b.Name,
+		}
+		switch {
+		case b.NIP != "":
+			id.Country = l10n.PL.ISO()
+			id.Code = cbc.Code(b.NIP)
+		case b.UEVatNumber != "":
+			id.Country = l10n.ISOCountryCode(b.UECode)
+			id.Code = cbc.Code(b.UEVatNumber)
+		case b.IDNumber != "":
+			id.Country = l10n.ISOCountryCode(b.CountryCode)
+			id.Code = cbc.Code(b.IDNumber)
+		}
+		if b.Address != nil {
+			id.Description = addressDescription(b.Address)
+		}
+		prc.Identities = append(prc.Identities, id)
+	}
+
+	// Corrections for a period report their amounts as rebates instead
+	if len(inv.Lines) == 0 && inv.Order == nil && inv.CorrectionPeriod == "" {
+		zero := num.MakeAmount(0, 2)
+		goblInv.Lines = []*bill.Line{
+			{
+				Quantity: num.MakeAmount(0, 0),
+				Item: &org.Item{
+					Name:  inv.CorrectionReason,
+					Price: &zero,
+				},
+				Ext: tax.Extensions{
+					ExtKeyPlaceholder: "1",
+				},
+			},
+		}
+		if goblInv.Lines[0].Item.Name == "" {
+			goblInv.Lines[0].Item.Name = "Correction of invoice data"
+		}
+	}
+}
+
+// newCorrectionPeriod describes the period of the discount or price reduction
+// granted on the corrected invoices, from the first preceding document with
+// a period.
+func newCorrectionPeriod(preceding []*org.DocumentRef) string {
+	for _, prc := range preceding {
+		if prc.Period != nil {
+			return fmt.Sprintf("%s - %s", prc.Period.Start, prc.Period.End)
+		}
+		if p := prc.Meta[MetaKeyCorrectionPeriod]; p != "" {
+			return p
+		}
+	}
+	return ""
+}
+
+// parseCorrectionPeriod sets the period of the corrected invoices on the
+// preceding document, or keeps it in the meta when it is not a date range.
+func parseCorrectionPeriod(prc *org.DocumentRef, period string) {
+	if period == "" {
+		return
+	}
+	if parts := strings.Split(period, " - "); len(parts) == 2 {
+		start, err1 := parseDate(parts[0])
+		end, err2 := parseDate(parts[1])
+		if err1 == nil && err2 == nil {
+			prc.Period = &cal.Period{Start: start, End: end}
+			return
+		}
+	}
+	prc.Meta = cbc.Meta{MetaKeyCorrectionPeriod: period}
+}
+
+// parseRebates converts the net amounts per VAT rate of corrections for a
+// period with no lines, such as rebates on all the invoices issued to the
+// buyer in the period, into document level charges with taxes.
+func (inv *Inv) parseRebates(goblInv *bill.Invoice) error {
+	if inv.CorrectionPeriod == "" || len(inv.Lines) > 0 || inv.Order != nil {
+		return nil
+	}
+
+	reason := inv.CorrectionReason
+	if reason == "" {
+		reason = "Rebate"
+	}
+
+	for _, r := range []struct {
+		category cbc.Code
+		net      string
+		vat      string
+	}{
+		{"1", inv.StandardRateNetSale, inv.StandardRateTax},
+		{"2", inv.ReducedRateNetSale, inv.ReducedRateTax},
+		{"3", inv.SuperReducedRateNetSale, inv.SuperReducedRateTax},
+		{"4", inv.TaxiRateNetSale, inv.TaxiRateTax},
+		{"5", inv.OSSNetSale, inv.OSSTax},
+		{"6.1", inv.ZeroTaxExceptIntraCommunityNetSale, ""},
+		{"6.2", inv.IntraCommunityNetSale, ""},
+		{"6.3", inv.ExportNetSale, ""},
+		{"7", inv.TaxExemptNetSale, ""},
+		{"8", inv.OutsideScopeNetSale, ""},
+		{"9", inv.ReverseChargeNetSale, ""},
+		{"10", inv.DomesticReverseChargeNetSale, ""},
+		{"11", inv.MarginNetSale, ""},
+	} {
+		if r.net == "" {
+			continue
+		}
+		amount, err := parseAmount(r.net)
+		if err != nil {
+			return fmt.Errorf("parsing rebate amount: %w", err)
+		}
+		combo, err := rebateTaxCombo(r.category, amount, r.vat)
+		if err != nil {
+			return err
+		}
+		goblInv.Charges = append(goblInv.Charges, &bill.Charge{
+			Reason: reason,
+			Amount: amount,
+			Taxes:  tax.Set{combo},
+		})
+	}
+
+	return nil
+}
+
+// rebateTaxCombo returns the VAT combo of the rebates of a tax category. The
+// percentage of the taxed categories is the one of their VAT amount on the net
+// amount, as the rebates may refer to invoices issued with former or foreign
+// (OSS) rates.
+func rebateTaxCombo(category cbc.Code, net num.Amount, vat string) (*tax.Combo, error) {
+	combo := &tax.Combo{
+		Category: tax.CategoryVAT,
+		Ext: tax.Extensions{
+			favat.ExtKeyTaxCategory: category,
+		},
+	}
+	switch category {
+	case "1", "2", "3", "4", "5":
+		if vat == "" || net.IsZero() {
+			return nil, fmt.Errorf("rebate of tax category %s: missing VAT amount", category)
+		}
+		amount, err := parseAmount(vat)
+		if err != nil {
+			return nil, fmt.Errorf("parsing rebate VAT amount: %w", err)
+		}
+		percent := num.NewPercentage(amount.Upscale(6).Divide(net).Rescale(3).Value(), 3)
+		if info := parseVATRate(percent.Amount().MinimalString()); info.TaxCategory == category {
+			return info.taxCombo(), nil
+		}
+		combo.Key = tax.KeyStandard
+		combo.Percent = percent
+	case "11":
+		// The VAT of margin schemes is included in the amount, and not shown
+		combo.Key = tax.KeyStandard
+		combo.Percent = num.NewPercentage(0, 3)
+	default:
+		info := parseVATRate(vatRate(combo))
+		combo.Key = info.Key
+		combo.Percent = info.Percent
+	}
+	return combo, nil
+}
+
+// correctedBuyer returns the buyer data before correction linked to the
+// buyer, or the first one when the buyer has no link.
+func (d *Invoice) correctedBuyer() *CorrectedBuyer {
+	buyers := d.Inv.CorrectedBuyers
+	if len(buyers) == 0 {
+		return nil
+	}
+	if d.Buyer != nil && d.Buyer.BuyerID != "" {
+		for _, b := range buyers {
+			if b.BuyerID == d.Buyer.BuyerID {
+				return b
+			}
+		}
+	}
+	return buyers[0]
+}
+
+// withoutPlaceholders removes the lines marked as placeholders in corrections
+// of party data or of the invoice number only.
+func withoutPlaceholders(lines []*bill.Line) []*bill.Line {
+	var result []*bill.Line
+	for _, line := range lines {
+		if line.Ext.Get(ExtKeyPlaceholder) != "1" {
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+func addressDescription(addr *Address) string {
+	if addr.AddressL2 == "" {
+		return addr.AddressL1
+	}
+	return addr.AddressL1 + ", " + addr.AddressL2
+}
@@ -1,15 +1,21 @@
 package ksef_test
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	ksef "github.com/invopop/gobl.ksef"
+	"github.com/invopop/gobl.ksef/test"
 	"github.com/invopop/gobl/addons/pl/favat"
+	"github.com/invopop/gobl/bill"
 	"github.com/invopop/gobl/cal"
 	"github.com/invopop/gobl/cbc"
 	"github.com/invopop/gobl/head"
 	"github.com/invopop/gobl/org"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewCorrectedInv(t *testing.T) {
@@ -131,3 +137,244 @@ func TestNewCorrectedInv(t *testing.T) {
 }
 
 
+
+func TestNewCorrectedSeller(t *testing.T) {
+	t.Run("should return nil without supplier identity", func(t *testing.T) {
+		inv := &bill.Invoice{Preceding: []*org.DocumentRef{{Code: "001"}}}
+		assert.Nil(t, ksef.NewCorrectedSeller(inv))
+	})
+
+	t.Run("should use supplier identity with current address", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Supplier: &org.Party{
+				Addresses: []*org.Address{
+					{Street: "ul. Nowa 1", Code: "00-001", Locality: "Warszawa", Country: "PL"},
+				},
+			},
+			Preceding: []*org.DocumentRef{
+				{
+					Code: "001",
+					Identities: []*org.Identity{
+						{Key: ksef.IdentityKeySupplier, Country: "PL", Code: "7251234561", Label: "Stara Nazwa"},
+					},
+				},
+			},
+		}
+
+		seller := ksef.NewCorrectedSeller(inv)
+		require.NotNil(t, seller)
+		assert.Equal(t, "PL", seller.VATPrefix)
+		assert.Equal(t, "7251234561", seller.NIP)
+		assert.Equal(t, "Stara Nazwa", seller.Name)
+		require.NotNil(t, seller.Address)
+		assert.Equal(t, "PL", seller.Address.CountryCode)
+	})
+}
+
+func TestNewCorrectedBuyers(t *testing.T) {
+	newInvoice := func(id *org.Identity) *bill.Invoice {
+		return &bill.Invoice{
+			Preceding: []*org.DocumentRef{
+				{Code: "001", Identities: []*org.Identity{id}},
+			},
+		}
+	}
+
+	t.Run("should map Polish tax code to NIP", func(t *testing.T) {
+		buyers := ksef.NewCorrectedBuyers(newInvoice(&org.Identity{
+			Key:         ksef.IdentityKeyCustomer,
+			Country:     "PL",
+			Code:        "7251234561",
+			Label:       "Klient Błędny",
+			Description: "ul. Stara 5, 00-950, Warszawa",
+		}))
+		require.Len(t, buyers, 1)
+		assert.Equal(t, &ksef.CorrectedBuyer{
+			NIP:  "7251234561",
+			Name: "Klient Błędny",
+			Address: &ksef.Address{
+				CountryCode: "PL",
+				AddressL1:   "ul. Stara 5, 00-950, Warszawa",
+			},
+			BuyerID: "1",
+		}, buyers[0])
+	})
+
+	t.Run("should map EU tax code to VAT number", func(t *testing.T) {
+		buyers := ksef.NewCorrectedBuyers(newInvoice(&org.Identity{
+			Key:     ksef.IdentityKeyCustomer,
+			Country: "DE",
+			Code:    "111111125",
+		}))
+		require.Len(t, buyers, 1)
+		assert.Equal(t, "DE", buyers[0].UECode)
+		assert.Equal(t, "111111125", buyers[0].UEVatNumber)
+	})
+
+	t.Run("should set no ID marker without code", func(t *testing.T) {
+		buyers := ksef.NewCorrectedBuyers(newInvoice(&org.Identity{
+			Key:   ksef.IdentityKeyCustomer,
+			Label: "Klient",
+		}))
+		require.Len(t, buyers, 1)
+		assert.Equal(t, 1, buyers[0].NoID)
+	})
+}
+
+func TestCorrectionPlaceholders(t *testing.T) {
+	load := func(t *testing.T) *bill.Invoice {
+		t.Helper()
+		env, err := test.LoadTestEnvelope("credit-note-buyer-data.json")
+		require.NoError(t, err)
+		return env.Extract().(*bill.Invoice)
+	}
+
+	t.Run("should not report placeholder lines", func(t *testing.T) {
+		inv := ksef.NewFavatInv(load(t))
+
+		assert.Empty(t, inv.Lines)
+	})
+
+	t.Run("should report lines without quantity that are not placeholders", func(t *testing.T) {
+		goblInv := load(t)
+		line := *goblInv.Lines[0]
+		line.Index = 2
+		line.Ext = nil
+		line.Item = &org.Item{Name: "Free sample", Price: line.Item.Price}
+		goblInv.Lines = append(goblInv.Lines, &line)
+
+		inv := ksef.NewFavatInv(goblInv)
+
+		require.Len(t, inv.Lines, 1)
+		assert.Equal(t, "Free sample", inv.Lines[0].Name)
+	})
+}
+
+func TestParseCorrectedParties(t *testing.T) {
+	t.Run("should restore buyer data before correction", func(t *testing.T) {
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", "credit-note-buyer-data.xml"))
+		require.NoError(t, err)
+
+		env, err := ksef.ParseKSeF(data)
+		require.NoError(t, err)
+		inv, ok := env.Extract().(*bill.Invoice)
+		require.True(t, ok)
+
+		require.Len(t, inv.Preceding, 1)
+		require.Len(t, inv.Preceding[0].Identities, 1)
+		id := inv.Preceding[0].Identities[0]
+		assert.Equal(t, ksef.IdentityKeyCustomer, id.Key)
+		assert.Equal(t, cbc.Code("7251234561"), id.Code)
+		assert.Equal(t, "Klient Błędny Sp. z o.o.", id.Label)
+		assert.Equal(t, "ul. Stara 5, 00-950, Warszawa", id.Description)
+
+		require.Len(t, inv.Lines, 1)
+		assert.True(t, inv.Lines[0].Quantity.IsZero())
+		assert.Equal(t, cbc.Code("1"), inv.Lines[0].Ext.Get(ksef.ExtKeyPlaceholder))
+	})
+}
+
+func TestParseRebates(t *testing.T) {
+	t.Run("should parse period corrections without lines into charges", func(t *testing.T) {
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", "credit-note-rebate.xml"))
+		require.NoError(t, err)
+
+		env, err := ksef.ParseKSeF(data)
+		require.NoError(t, err)
+		inv, ok := env.Extract().(*bill.Invoice)
+		require.True(t, ok)
+
+		require.Len(t, inv.Preceding, 3)
+		for _, prc := range inv.Preceding {
+			require.NotNil(t, prc.Period)
+			assert.Equal(t, "2026-01-01", prc.Period.Start.String())
+			assert.Equal(t, "2026-03-31", prc.Period.End.String())
+		}
+
+		assert.Empty(t, inv.Lines)
+		require.Len(t, inv.Charges, 2)
+		assert.Equal(t, "-500.00", inv.Charges[0].Amount.String())
+		assert.Equal(t, cbc.Code("1"), inv.Charges[0].Taxes[0].Ext[favat.ExtKeyTaxCategory])
+		assert.Equal(t, "-200.00", inv.Charges[1].Amount.String())
+		assert.Equal(t, "-831.00", inv.Totals.Payable.String())
+	})
+
+	parse := func(t *testing.T, replacements ...string) *bill.Invoice {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", "credit-note-rebate.xml"))
+		require.NoError(t, err)
+		xml := strings.NewReplacer(replacements...).Replace(string(data))
+
+		env, err := ksef.ParseKSeF([]byte(xml))
+		require.NoError(t, err)
+		require.NoError(t, env.Validate())
+		return env.Extract().(*bill.Invoice)
+	}
+
+	t.Run("should derive the rate from the VAT amount", func(t *testing.T) {
+		inv := parse(t,
+			"<P_14_1>-115.00</P_14_1>", "<P_14_1>-110.00</P_14_1>",
+			"<P_15>-831.00</P_15>", "<P_15>-826.00</P_15>",
+		)
+
+		require.Len(t, inv.Charges, 2)
+		tc := inv.Charges[0].Taxes[0]
+		assert.Equal(t, cbc.Code("1"), tc.Ext[favat.ExtKeyTaxCategory])
+		assert.Equal(t, "22.0%", tc.Percent.String())
+		assert.Equal(t, "-826.00", inv.Totals.Payable.String())
+	})
+
+	t.Run("should parse OSS and margin rebates", func(t *testing.T) {
+		inv := parse(t,
+			"<P_13_2>-200.00</P_13_2>", "<P_13_5>-200.00</P_13_5>",
+			"<P_14_2>-16.00</P_14_2>", "<P_14_5>-40.00</P_14_5>",
+			"<P_15>-831.00</P_15>", "<P_13_11>-100.00</P_13_11>\n    <P_15>-955.00</P_15>",
+		)
+
+		require.Len(t, inv.Charges, 3)
+		oss := inv.Charges[1].Taxes[0]
+		assert.Equal(t, cbc.Code("5"), oss.Ext[favat.ExtKeyTaxCategory])
+		assert.Equal(t, "20.0%", oss.Percent.String())
+		margin := inv.Charges[2]
+		assert.Equal(t, "-100.00", margin.Amount.String())
+		assert.Equal(t, cbc.Code("11"), margin.Taxes[0].Ext[favat.ExtKeyTaxCategory])
+		assert.Equal(t, "-955.00", inv.Totals.Payable.String())
+	})
+}
+
+func TestParseCorrectedInv(t *testing.T) {
+	parse := func(t *testing.T, name string) *bill.Invoice {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", name))
+		require.NoError(t, err)
+
+		env, err := ksef.ParseKSeF(data)
+		require.NoError(t, err)
+		inv, ok := env.Extract().(*bill.Invoice)
+		require.True(t, ok)
+		return inv
+	}
+
+	t.Run("should restore KSeF number and amount before correction", func(t *testing.T) {
+		inv := parse(t, "credit-note-prepayment.xml")
+
+		require.Len(t, inv.Preceding, 1)
+		prc := inv.Preceding[0]
+		require.Len(t, prc.Stamps, 1)
+		assert.Equal(t, favat.StampKSEFNumber, prc.Stamps[0].Provider)
+		assert.Equal(t, "8126178616-20260122-0100A0039876-89", prc.Stamps[0].Value)
+		require.NotNil(t, prc.Payable)
+		assert.Equal(t, "6150.00", prc.Payable.String())
+	})
+
+	t.Run("should restore corrected invoice number", func(t *testing.T) {
+		inv := parse(t, "credit-note-wrong-number.xml")
+
+		require.Len(t, inv.Preceding, 1)
+		assert.Equal(t, cbc.Code("INVOICE-001"), inv.Preceding[0].Code)
+		assert.Equal(t, "INVOICE-002", inv.Preceding[0].Meta[ksef.MetaKeyCorrectedNumber])
+		require.Len(t, inv.Lines, 1)
+		assert.True(t, inv.Lines[0].Quantity.IsZero())
+		assert.Equal(t, cbc.Code("1"), inv.Lines[0].Ext.Get(ksef.ExtKeyPlaceholder))
+	})
+}
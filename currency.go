@@ -0,0 +1,316 @@
+package ksef
+
+import (
+	"fmt"
+
+	"github.com/invopop/gobl/addons/pl/favat"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/tax"
+)
+
+// ExchangeRateProvider provides the exchange rates to PLN used to convert the
+// VAT amounts of invoices in foreign currencies.
+type ExchangeRateProvider interface {
+	// ExchangeRate returns the rate from the currency to PLN that applies
+	// to a supply with the given tax point date.
+	ExchangeRate(cur currency.Code, date cal.Date) (*currency.ExchangeRate, error)
+}
+
+// plnTaxCategories are the tax categories with VAT amounts that must be
+// converted to PLN on invoices in foreign currencies (P_14_1W to P_14_4W)
+var plnTaxCategories = []cbc.Code{"1", "2", "3", "4"}
+
+// setExchangeRates sets the exchange rates to PLN of invoices in foreign
+// currencies, together with the VAT amounts converted to PLN. Advance
+// invoices have a single rate, the one without a date or else the one at
+// the date of the last advance received, while the rate of other invoices is
+// set per line, at the end of the line period if any, so that lines delivered
+// on different dates may use different rates.
+func (inv *Inv) setExchangeRates(invoice *bill.Invoice, lines map[int]*bill.Line) {
+	if invoice.Currency == "" || invoice.Currency == currency.PLN || invoice.Totals == nil {
+		return
+	}
+
+	if invoice.HasTags(tax.TagPartial) {
+		rate := advanceExchangeRate(invoice)
+		if rate == nil {
+			return
+		}
+		inv.ExchangeRate = rate.Amount.String()
+		inv.setConvertedTaxes(convertedTotalTaxes(invoicedTaxes(invoice), rate.Amount))
+		return
+	}
+
+	var rates []num.Amount
+	for _, l := range inv.Lines {
+		line, ok := lines[l.LineNumber]
+		if !ok {
+			continue
+		}
+		rate := plnExchangeRate(invoice, lineDate(line))
+		if rate == nil {
+			continue
+		}
+		l.CurrencyRate = rate.Amount.String()
+		rates = append(rates, rate.Amount)
+	}
+	if len(rates) == 0 {
+		return
+	}
+
+	// Lines with different rates need their VAT amounts converted one by one
+	for _, r := range rates[1:] {
+		if !r.Equals(rates[0]) {
+			inv.setConvertedTaxes(convertedLineTaxes(invoice))
+			return
+		}
+	}
+	inv.setConvertedTaxes(convertedTotalTaxes(invoice.Totals.Taxes, rates[0]))
+}
+
+// advanceExchangeRate returns the exchange rate to PLN of an advance invoice,
+// which is the rate without a date, or else the rate set at the date of the
+// last advance received that has one. Advances without a date are received on
+// the invoice issue date.
+func advanceExchangeRate(invoice *bill.Invoice) *currency.ExchangeRate {
+	if rate := plnExchangeRate(invoice, nil); rate != nil {
+		return rate
+	}
+	if invoice.Payment == nil {
+		return nil
+	}
+	advances := invoice.Payment.Advances
+	for i := len(advances) - 1; i >= 0; i-- {
+		date := invoice.IssueDate
+		if advances[i].Date != nil {
+			date = *advances[i].Date
+		}
+		if rate := plnExchangeRate(invoice, &date); rate != nil {
+			return rate
+		}
+	}
+	return nil
+}
+
+// lineDate returns the date used to find the exchange rate of a line, which
+// is the end of the line period.
+func lineDate(line *bill.Line) *cal.Date {
+	if line.Period == nil {
+		return nil
+	}
+	return &line.Period.End
+}
+
+// addProvidedExchangeRates adds the exchange rates to PLN missing from an
+// invoice in a foreign currency: the rate at the operation or issue date
+// without a date, and the rates at the dates of the lines and advance
+// payments, so that they may be converted with their own rate.
+func addProvidedExchangeRates(inv *bill.Invoice, provider ExchangeRateProvider) error {
+	if inv.Currency == "" || inv.Currency == currency.PLN {
+		return nil
+	}
+
+	if plnExchangeRate(inv, nil) == nil {
+		date := inv.IssueDate
+		if inv.OperationDate != nil {
+			date = *inv.OperationDate
+		}
+		if err := addProvidedExchangeRate(inv, provider, date, nil); err != nil {
+			return err
+		}
+	}
+
+	var dates []*cal.Date
+	for _, line := range inv.Lines {
+		dates = append(dates, lineDate(line))
+	}
+	if inv.Payment != nil {
+		for _, adv := range inv.Payment.Advances {
+			dates = append(dates, adv.Date)
+		}
+	}
+	for _, date := range dates {
+		if date == nil {
+			continue
+		}
+		if rate := plnExchangeRate(inv, date); rate != nil && rate.At != nil {
+			continue
+		}
+		at := cal.NewDateTime(date.Year, date.Month, date.Day, 0, 0, 0)
+		if err := addProvidedExchangeRate(inv, provider, *date, at); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addProvidedExchangeRate(inv *bill.Invoice, provider ExchangeRateProvider, date cal.Date, at *cal.DateTime) error {
+	rate, err := provider.ExchangeRate(inv.Currency, date)
+	if err != nil {
+		return fmt.Errorf("%s rate on %s: %w", inv.Currency, date, err)
+	}
+	inv.ExchangeRates = append(inv.ExchangeRates, &currency.ExchangeRate{
+		From:   inv.Currency,
+		To:     currency.PLN,
+		At:     at,
+		Source: rate.Source,
+		Amount: rate.Amount,
+	})
+	return nil
+}
+
+// convertedTotalTaxes converts the VAT amounts of each tax category to PLN
+// with the given rate.
+func convertedTotalTaxes(taxes *tax.Total, rate num.Amount) map[cbc.Code]num.Amount {
+	result := make(map[cbc.Code]num.Amount)
+	if taxes == nil {
+		return result
+	}
+	for _, cat := range taxes.Categories {
+		if cat.Code != tax.CategoryVAT {
+			continue
+		}
+		for _, r := range cat.Rates {
+			result[r.Ext.Get(favat.ExtKeyTaxCategory)] = r.Amount.Multiply(rate)
+		}
+	}
+	return result
+}
+
+// convertedLineTaxes sums the VAT amounts of each line converted to PLN with
+// the rate of the line, per tax category.
+func convertedLineTaxes(invoice *bill.Invoice) map[cbc.Code]num.Amount {
+	result := make(map[cbc.Code]num.Amount)
+	for _, line := range invoice.Lines {
+		tc := line.Taxes.Get(tax.CategoryVAT)
+		if tc == nil || tc.Percent == nil || line.Total == nil {
+			continue
+		}
+		rate := plnExchangeRate(invoice, lineDate(line))
+		if rate == nil {
+			continue
+		}
+		key := tc.Ext.Get(favat.ExtKeyTaxCategory)
+		amount := tc.Percent.Of(line.Total.Rescale(6))
+		if invoice.Tax != nil && invoice.Tax.PricesInclude == tax.CategoryVAT {
+			amount = tc.Percent.From(line.Total.Rescale(6))
+		}
+		amount = amount.Multiply(rate.Amount)
+		if sum, ok := result[key]; ok {
+			amount = sum.Add(amount)
+		}
+		result[key] = amount
+	}
+	return result
+}
+
+// setConvertedTaxes sets the VAT amounts converted to PLN of the tax
+// categories that require them.
+func (inv *Inv) setConvertedTaxes(amounts map[cbc.Code]num.Amount) {
+	fields := []*string{
+		&inv.StandardRateTaxConvertedToPln,
+		&inv.ReducedRateTaxConvertedToPln,
+		&inv.SuperReducedRateTaxConvertedToPln,
+		&inv.TaxiRateTaxConvertedToPln,
+	}
+	subunits := currency.PLN.Def().Subunits
+	for i, cat := range plnTaxCategories {
+		if amount, ok := amounts[cat]; ok {
+			*fields[i] = amount.Rescale(subunits).String()
+		}
+	}
+}
+
+// parseExchangeRates converts the exchange rates to PLN of invoices in
+// foreign currencies into GOBL exchange rates. The first rate found is set
+// without a date, and the rates of lines that differ from it are set at the
+// line completion date. When no rate is given, it is derived from the VAT
+// amounts converted to PLN.
+func (inv *Inv) parseExchangeRates(goblInv *bill.Invoice) error {
+	if goblInv.Currency == "" || goblInv.Currency == currency.PLN {
+		return nil
+	}
+
+	if inv.ExchangeRate != "" {
+		rate, err := parseAmount(inv.ExchangeRate)
+		if err != nil {
+			return fmt.Errorf("parsing exchange rate: %w", err)
+		}
+		addExchangeRate(goblInv, rate, nil)
+	}
+
+	for _, l := range inv.Lines {
+		if l.CurrencyRate == "" {
+			continue
+		}
+		rate, err := parseAmount(l.CurrencyRate)
+		if err != nil {
+			return fmt.Errorf("parsing line exchange rate: %w", err)
+		}
+		var date *cal.Date
+		if l.CompletionDate != "" {
+			d, err := parseDate(l.CompletionDate)
+			if err != nil {
+				return fmt.Errorf("parsing line completion date: %w", err)
+			}
+			date = &d
+		}
+		addExchangeRate(goblInv, rate, date)
+	}
+
+	if plnExchangeRate(goblInv, nil) != nil {
+		return nil
+	}
+	for _, t := range []struct{ tax, converted string }{
+		{inv.StandardRateTax, inv.StandardRateTaxConvertedToPln},
+		{inv.ReducedRateTax, inv.ReducedRateTaxConvertedToPln},
+		{inv.SuperReducedRateTax, inv.SuperReducedRateTaxConvertedToPln},
+		{inv.TaxiRateTax, inv.TaxiRateTaxConvertedToPln},
+	} {
+		if t.tax == "" || t.converted == "" {
+			continue
+		}
+		amount, err := parseAmount(t.tax)
+		if err != nil {
+			return fmt.Errorf("parsing tax amount: %w", err)
+		}
+		converted, err := parseAmount(t.converted)
+		if err != nil {
+			return fmt.Errorf("parsing tax amount converted to PLN: %w", err)
+		}
+		if amount.IsZero() {
+			continue
+		}
+		addExchangeRate(goblInv, converted.Rescale(6).Divide(amount).Rescale(4), nil)
+		break
+	}
+
+	return nil
+}
+
+// addExchangeRate adds an exchange rate from the invoice currency to PLN. A
+// rate without date is only added when there is none, and a rate at a date
+// only when it differs from the rate without date.
+func addExchangeRate(goblInv *bill.Invoice, amount num.Amount, date *cal.Date) {
+	current := plnExchangeRate(goblInv, date)
+	if current != nil && current.Amount.Equals(amount) {
+		return
+	}
+	if current != nil && (date == nil || current.At != nil) {
+		return
+	}
+	rate := &currency.ExchangeRate{
+		From:   goblInv.Currency,
+		To:     currency.PLN,
+		Amount: amount,
+	}
+	if current != nil {
+		rate.At = cal.NewDateTime(date.Year, date.Month, date.Day, 0, 0, 0)
+	}
+	goblInv.ExchangeRates = append(goblInv.ExchangeRates, rate)
+}
@@ -0,0 +1,182 @@
+package ksef_test
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	ksef "github.com/invopop/gobl.ksef"
+	"github.com/invopop/gobl.ksef/test"
+	"github.com/invopop/gobl/addons/pl/favat"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/org"
+	"github.com/invopop/gobl/pay"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFavatInvExchangeRates(t *testing.T) {
+	newLine := func(i int, total int64, percent int64, cat cbc.Code, day int) *bill.Line {
+		amount := num.MakeAmount(total, 2)
+		return &bill.Line{
+			Index:    i,
+			Quantity: num.MakeAmount(1, 0),
+			Item:     &org.Item{Name: "Item", Price: &amount},
+			Period:   &cal.Period{Start: cal.MakeDate(2026, 1, day), End: cal.MakeDate(2026, 1, day)},
+			Total:    &amount,
+			Taxes: tax.Set{
+				{
+					Category: tax.CategoryVAT,
+					Percent:  num.NewPercentage(percent, 2),
+					Ext:      tax.Extensions{favat.ExtKeyTaxCategory: cat},
+				},
+			},
+		}
+	}
+	foreignInvoice := func() *bill.Invoice {
+		return &bill.Invoice{
+			Currency: currency.EUR,
+			ExchangeRates: []*currency.ExchangeRate{
+				{From: currency.EUR, To: currency.PLN, Amount: num.MakeAmount(42500, 4)},
+			},
+			Tax: &bill.Tax{Ext: tax.Extensions{favat.ExtKeyInvoiceType: "VAT"}},
+			Lines: []*bill.Line{
+				newLine(1, 100000, 23, "1", 5),
+				newLine(2, 100000, 23, "1", 12),
+			},
+			Totals: &bill.Totals{
+				Taxes: &tax.Total{
+					Categories: []*tax.CategoryTotal{
+						{
+							Code: tax.CategoryVAT,
+							Rates: []*tax.RateTotal{
+								{
+									Ext:    tax.Extensions{favat.ExtKeyTaxCategory: "1"},
+									Base:   num.MakeAmount(200000, 2),
+									Amount: num.MakeAmount(46000, 2),
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	t.Run("should not set rates for PLN invoices", func(t *testing.T) {
+		inv := foreignInvoice()
+		inv.Currency = currency.PLN
+
+		invoice := ksef.NewFavatInv(inv)
+
+		assert.Empty(t, invoice.StandardRateTaxConvertedToPln)
+		assert.Empty(t, invoice.Lines[0].CurrencyRate)
+	})
+
+	t.Run("should convert totals with a single rate", func(t *testing.T) {
+		invoice := ksef.NewFavatInv(foreignInvoice())
+
+		assert.Equal(t, "4.2500", invoice.Lines[0].CurrencyRate)
+		assert.Equal(t, "4.2500", invoice.Lines[1].CurrencyRate)
+		assert.Equal(t, "1955.00", invoice.StandardRateTaxConvertedToPln)
+		assert.Empty(t, invoice.ExchangeRate)
+	})
+
+	t.Run("should convert each line with its own rate", func(t *testing.T) {
+		inv := foreignInvoice()
+		inv.ExchangeRates = append(inv.ExchangeRates, &currency.ExchangeRate{
+			From:   currency.EUR,
+			To:     currency.PLN,
+			At:     cal.NewDateTime(2026, 1, 5, 0, 0, 0),
+			Amount: num.MakeAmount(42310, 4),
+		})
+
+		invoice := ksef.NewFavatInv(inv)
+
+		assert.Equal(t, "4.2310", invoice.Lines[0].CurrencyRate)
+		assert.Equal(t, "4.2500", invoice.Lines[1].CurrencyRate)
+		// 230.00 * 4.2310 + 230.00 * 4.2500
+		assert.Equal(t, "1950.63", invoice.StandardRateTaxConvertedToPln)
+	})
+
+	t.Run("should set a single rate on advance invoices", func(t *testing.T) {
+		inv := foreignInvoice()
+		inv.SetTags(tax.TagPartial)
+		inv.Totals.TotalWithTax = num.MakeAmount(246000, 2)
+
+		invoice := ksef.NewFavatInv(inv)
+
+		assert.Equal(t, "4.2500", invoice.ExchangeRate)
+		assert.Equal(t, "1955.00", invoice.StandardRateTaxConvertedToPln)
+	})
+
+	t.Run("should use the rate at the last advance date on advance invoices", func(t *testing.T) {
+		inv := foreignInvoice()
+		inv.SetTags(tax.TagPartial)
+		inv.IssueDate = cal.MakeDate(2026, 1, 20)
+		inv.Totals.TotalWithTax = num.MakeAmount(246000, 2)
+		inv.ExchangeRates = []*currency.ExchangeRate{
+			{From: currency.EUR, To: currency.PLN, At: cal.NewDateTime(2026, 1, 10, 0, 0, 0), Amount: num.MakeAmount(42310, 4)},
+			{From: currency.EUR, To: currency.PLN, At: cal.NewDateTime(2026, 1, 20, 0, 0, 0), Amount: num.MakeAmount(42150, 4)},
+		}
+		inv.Payment = &bill.PaymentDetails{
+			Advances: []*pay.Advance{
+				{Date: cal.NewDate(2026, 1, 10), Description: "Advance", Amount: num.MakeAmount(100000, 2)},
+				{Date: cal.NewDate(2026, 1, 20), Description: "Advance", Amount: num.MakeAmount(146000, 2)},
+			},
+		}
+		advances := num.MakeAmount(246000, 2)
+		inv.Totals.Advances = &advances
+		inv.Totals.Due = num.NewAmount(0, 2)
+		inv.Totals.Taxes.Categories[0].Rates[0].Percent = num.NewPercentage(23, 2)
+
+		invoice := ksef.NewFavatInv(inv)
+
+		assert.Equal(t, "4.2150", invoice.ExchangeRate)
+		assert.Equal(t, "1938.90", invoice.StandardRateTaxConvertedToPln)
+	})
+}
+
+func TestParseExchangeRates(t *testing.T) {
+	load := func(t *testing.T) []byte {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", "invoice-foreign-currency.xml"))
+		require.NoError(t, err)
+		return data
+	}
+	parse := func(t *testing.T, data []byte) *bill.Invoice {
+		t.Helper()
+		env, err := ksef.ParseKSeF(data)
+		require.NoError(t, err)
+		inv, ok := env.Extract().(*bill.Invoice)
+		require.True(t, ok)
+		return inv
+	}
+
+	t.Run("should parse line rates that differ at the line date", func(t *testing.T) {
+		inv := parse(t, load(t))
+
+		require.Len(t, inv.ExchangeRates, 2)
+		assert.Nil(t, inv.ExchangeRates[0].At)
+		assert.Equal(t, "4.2310", inv.ExchangeRates[0].Amount.String())
+		require.NotNil(t, inv.ExchangeRates[1].At)
+		assert.Equal(t, "2026-01-12", inv.ExchangeRates[1].At.Date().String())
+		assert.Equal(t, "4.2500", inv.ExchangeRates[1].Amount.String())
+	})
+
+	t.Run("should derive the rate from the converted tax amounts", func(t *testing.T) {
+		data := regexp.MustCompile(`\s*<KursWaluty>[^<]*</KursWaluty>`).ReplaceAll(load(t), nil)
+		inv := parse(t, data)
+
+		require.Len(t, inv.ExchangeRates, 1)
+		assert.Equal(t, currency.EUR, inv.ExchangeRates[0].From)
+		assert.Equal(t, currency.PLN, inv.ExchangeRates[0].To)
+		assert.Equal(t, "4.2310", inv.ExchangeRates[0].Amount.String())
+	})
+}
@@ -0,0 +1,353 @@
+package ksef
+
+import (
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/i18n"
+	"github.com/invopop/gobl/tax"
+)
+
+// Extension keys for KSeF fields not covered by the FA_VAT addon.
+const (
+	// ExtKeyAuthorizedRole marks a party as the authorized entity
+	// (PodmiotUpowazniony) and holds its role (RolaPU).
+	ExtKeyAuthorizedRole cbc.Key = "pl-ksef-authorized-role"
+
+	// ExtKeyBeforeCorrection marks a correction line with the state before
+	// the correction (StanPrzed). Its amounts are negated in GOBL so that the
+	// invoice totals only include the net correction.
+	ExtKeyBeforeCorrection cbc.Key = "pl-ksef-before-correction"
+
+	// ExtKeyPlaceholder marks a line added only because GOBL requires at
+	// least one, in corrections of party data or of the invoice number,
+	// which have no lines in KSeF.
+	ExtKeyPlaceholder cbc.Key = "pl-ksef-placeholder"
+
+	// ExtKeyGTU holds the code of the group of goods and services (GTU) of a
+	// line, set on the line or its item.
+	ExtKeyGTU cbc.Key = "pl-ksef-gtu"
+
+	// ExtKeyProcedure holds the code of the procedure (Procedura) that
+	// applies to a line, set on the line or its item.
+	ExtKeyProcedure cbc.Key = "pl-ksef-procedure"
+
+	// ExtKeyLineVAT marks an invoice with the VAT amount reported on each
+	// line (P_11Vat), as allowed by art. 106e sec. 10 of the VAT act.
+	ExtKeyLineVAT cbc.Key = "pl-ksef-line-vat"
+
+	// ExtKeyTaxpayerStatus holds the status of a supplier in liquidation,
+	// restructuring, bankruptcy or inheritance (StatusInfoPodatnika).
+	ExtKeyTaxpayerStatus cbc.Key = "pl-ksef-taxpayer-status"
+
+	// ExtKeyOtherRole marks a third party (Podmiot3) with a role other than
+	// the KSeF ones (RolaInna), described by the party label.
+	ExtKeyOtherRole cbc.Key = "pl-ksef-other-role"
+)
+
+// orderLineProcedures are the procedure codes allowed on the order lines of
+// advance invoices (ProceduraZ)
+var orderLineProcedures = []cbc.Code{"WSTO_EE", "IED", "TT_D", "B_SPV", "B_SPV_DOSTAWA", "B_MPV_PROWIZJA"}
+
+var extensions = []*cbc.Definition{
+	{
+		Key: ExtKeyAuthorizedRole,
+		Name: i18n.String{
+			i18n.EN: "Authorized entity role",
+			i18n.PL: "Rola podmiotu upoważnionego",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: "1",
+				Name: i18n.String{
+					i18n.EN: "Enforcement authority",
+					i18n.PL: "Organ egzekucyjny",
+				},
+			},
+			{
+				Code: "2",
+				Name: i18n.String{
+					i18n.EN: "Court bailiff",
+					i18n.PL: "Komornik sądowy",
+				},
+			},
+			{
+				Code: "3",
+				Name: i18n.String{
+					i18n.EN: "Tax representative",
+					i18n.PL: "Przedstawiciel podatkowy",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyBeforeCorrection,
+		Name: i18n.String{
+			i18n.EN: "State before correction",
+			i18n.PL: "Stan przed korektą",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: "1",
+				Name: i18n.String{
+					i18n.EN: "Line before correction",
+					i18n.PL: "Wiersz przed korektą",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyPlaceholder,
+		Name: i18n.String{
+			i18n.EN: "Placeholder line",
+			i18n.PL: "Wiersz zastępczy",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: "1",
+				Name: i18n.String{
+					i18n.EN: "Line not reported in KSeF",
+					i18n.PL: "Wiersz nieprzekazywany do KSeF",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyLineVAT,
+		Name: i18n.String{
+			i18n.EN: "VAT amount per line",
+			i18n.PL: "Kwota podatku w wierszu",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: "1",
+				Name: i18n.String{
+					i18n.EN: "VAT amount reported on each line",
+					i18n.PL: "Kwota podatku podana w każdym wierszu",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyTaxpayerStatus,
+		Name: i18n.String{
+			i18n.EN: "Taxpayer status",
+			i18n.PL: "Status podatnika",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: "1",
+				Name: i18n.String{
+					i18n.EN: "In liquidation",
+					i18n.PL: "W stanie likwidacji",
+				},
+			},
+			{
+				Code: "2",
+				Name: i18n.String{
+					i18n.EN: "In restructuring proceedings",
+					i18n.PL: "W trakcie postępowania restrukturyzacyjnego",
+				},
+			},
+			{
+				Code: "3",
+				Name: i18n.String{
+					i18n.EN: "In bankruptcy",
+					i18n.PL: "W stanie upadłości",
+				},
+			},
+			{
+				Code: "4",
+				Name: i18n.String{
+					i18n.EN: "Enterprise in inheritance",
+					i18n.PL: "Przedsiębiorstwo w spadku",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyOtherRole,
+		Name: i18n.String{
+			i18n.EN: "Other third party role",
+			i18n.PL: "Inna rola podmiotu trzeciego",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: "1",
+				Name: i18n.String{
+					i18n.EN: "Other entity",
+					i18n.PL: "Inny podmiot",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyGTU,
+		Name: i18n.String{
+			i18n.EN: "Group of goods and services",
+			i18n.PL: "Grupa towarów i usług",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: "GTU_01",
+				Name: i18n.String{
+					i18n.EN: "Alcoholic beverages",
+					i18n.PL: "Napoje alkoholowe",
+				},
+			},
+			{
+				Code: "GTU_02",
+				Name: i18n.String{
+					i18n.EN: "Motor fuels",
+					i18n.PL: "Paliwa silnikowe",
+				},
+			},
+			{
+				Code: "GTU_03",
+				Name: i18n.String{
+					i18n.EN: "Heating oil and lubricating oils",
+					i18n.PL: "Oleje opałowe i smarowe",
+				},
+			},
+			{
+				Code: "GTU_04",
+				Name: i18n.String{
+					i18n.EN: "Tobacco products",
+					i18n.PL: "Wyroby tytoniowe",
+				},
+			},
+			{
+				Code: "GTU_05",
+				Name: i18n.String{
+					i18n.EN: "Waste and scrap",
+					i18n.PL: "Odpady i złom",
+				},
+			},
+			{
+				Code: "GTU_06",
+				Name: i18n.String{
+					i18n.EN: "Electronic devices",
+					i18n.PL: "Urządzenia elektroniczne",
+				},
+			},
+			{
+				Code: "GTU_07",
+				Name: i18n.String{
+					i18n.EN: "Vehicles and vehicle parts",
+					i18n.PL: "Pojazdy oraz części samochodowe",
+				},
+			},
+			{
+				Code: "GTU_08",
+				Name: i18n.String{
+					i18n.EN: "Precious metals and jewellery",
+					i18n.PL: "Metale szlachetne i biżuteria",
+				},
+			},
+			{
+				Code: "GTU_09",
+				Name: i18n.String{
+					i18n.EN: "Medicines and medical devices",
+					i18n.PL: "Leki oraz wyroby medyczne",
+				},
+			},
+			{
+				Code: "GTU_10",
+				Name: i18n.String{
+					i18n.EN: "Buildings, structures and land",
+					i18n.PL: "Budynki, budowle i grunty",
+				},
+			},
+			{
+				Code: "GTU_11",
+				Name: i18n.String{
+					i18n.EN: "Greenhouse gas emission allowances",
+					i18n.PL: "Uprawnienia do emisji gazów cieplarnianych",
+				},
+			},
+			{
+				Code: "GTU_12",
+				Name: i18n.String{
+					i18n.EN: "Intangible services",
+					i18n.PL: "Usługi o charakterze niematerialnym",
+				},
+			},
+			{
+				Code: "GTU_13",
+				Name: i18n.String{
+					i18n.EN: "Transport and warehouse management services",
+					i18n.PL: "Usługi transportowe i gospodarki magazynowej",
+				},
+			},
+		},
+	},
+	{
+		Key: ExtKeyProcedure,
+		Name: i18n.String{
+			i18n.EN: "Procedure",
+			i18n.PL: "Oznaczenie procedury",
+		},
+		Values: []*cbc.Definition{
+			{
+				Code: "WSTO_EE",
+				Name: i18n.String{
+					i18n.EN: "Intra-community distance sales of goods",
+					i18n.PL: "Wewnątrzwspólnotowa sprzedaż towarów na odległość",
+				},
+			},
+			{
+				Code: "IED",
+				Name: i18n.String{
+					i18n.EN: "Supplies by an electronic interface",
+					i18n.PL: "Dostawa towarów przez interfejs elektroniczny",
+				},
+			},
+			{
+				Code: "TT_D",
+				Name: i18n.String{
+					i18n.EN: "Supply by the second taxpayer in a triangular transaction",
+					i18n.PL: "Dostawa przez drugiego w kolejności podatnika w transakcji trójstronnej",
+				},
+			},
+			{
+				Code: "I_42",
+				Name: i18n.String{
+					i18n.EN: "Import under customs procedure 42",
+					i18n.PL: "Wewnątrzwspólnotowa dostawa po imporcie w procedurze celnej 42",
+				},
+			},
+			{
+				Code: "I_63",
+				Name: i18n.String{
+					i18n.EN: "Import under customs procedure 63",
+					i18n.PL: "Wewnątrzwspólnotowa dostawa po imporcie w procedurze celnej 63",
+				},
+			},
+			{
+				Code: "B_SPV",
+				Name: i18n.String{
+					i18n.EN: "Transfer of a single-purpose voucher",
+					i18n.PL: "Transfer bonu jednego przeznaczenia",
+				},
+			},
+			{
+				Code: "B_SPV_DOSTAWA",
+				Name: i18n.String{
+					i18n.EN: "Supply covered by a single-purpose voucher",
+					i18n.PL: "Dostawa towarów lub usług, których dotyczy bon jednego przeznaczenia",
+				},
+			},
+			{
+				Code: "B_MPV_PROWIZJA",
+				Name: i18n.String{
+					i18n.EN: "Intermediary services for multi-purpose vouchers",
+					i18n.PL: "Usługi pośrednictwa w transferze bonu różnego przeznaczenia",
+				},
+			},
+		},
+	},
+}
+
+func init() {
+	for _, ext := range extensions {
+		tax.RegisterExtension(ext)
+	}
+}
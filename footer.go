@@ -0,0 +1,176 @@
+package ksef
+
+import (
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/org"
+)
+
+// Identity types of the supplier registry numbers reported in the footer
+const (
+	IdentityTypeKRS   cbc.Code = "KRS"
+	IdentityTypeREGON cbc.Code = "REGON"
+	IdentityTypeBDO   cbc.Code = "BDO"
+)
+
+// MetaKeyRegistryName is the supplier meta key holding its full name in the
+// registries (PelnaNazwa).
+const MetaKeyRegistryName cbc.Key = "registry-name"
+
+// Formats of the registry numbers: KRS has 10 digits, REGON 9 or 14, and BDO
+// up to 9
+var registryCodeRegexps = map[cbc.Code]*regexp.Regexp{
+	IdentityTypeKRS:   regexp.MustCompile(`^\d{10}$`),
+	IdentityTypeREGON: regexp.MustCompile(`^(?:\d{9}|\d{14})$`),
+	IdentityTypeBDO:   regexp.MustCompile(`^\d{1,9}$`),
+}
+
+// NoteSrcFooter is the source of the invoice notes printed in the footer
+// (StopkaFaktury) instead of the additional description.
+const NoteSrcFooter cbc.Key = "pl-ksef-footer"
+
+// maxFooterInfo is the maximum number of footer texts allowed by the schema
+const maxFooterInfo = 3
+
+// maxFooterText is the maximum number of characters of a footer text
+const maxFooterText = 3500
+
+// maxRegistryName is the maximum number of characters of the full name
+const maxRegistryName = 256
+
+// Footer defines the XML structure for KSeF footer (Stopka)
+type Footer struct {
+	Info       []*FooterInfo `xml:"Informacje,omitempty"` // up to 3
+	Registries []*Registry   `xml:"Rejestry,omitempty"`   // up to 100
+}
+
+// FooterInfo defines the XML structure for footer text
+type FooterInfo struct {
+	Text string `xml:"StopkaFaktury,omitempty"`
+}
+
+// Registry defines the XML structure for the entity numbers in other registries
+type Registry struct {
+	FullName string `xml:"PelnaNazwa,omitempty"`
+	KRS      string `xml:"KRS,omitempty"`
+	REGON    string `xml:"REGON,omitempty"`
+	BDO      string `xml:"BDO,omitempty"`
+}
+
+// validateFooter checks that the footer notes and the supplier registry
+// numbers can be reported: up to 3 notes of up to 3500 characters, and
+// registry numbers in their official formats.
+func validateFooter(inv *bill.Invoice) error {
+	var notes int
+	for _, note := range inv.Notes {
+		if note.Src != NoteSrcFooter {
+			continue
+		}
+		notes++
+		if n := utf8.RuneCountInString(note.Text); n > maxFooterText {
+			return fmt.Errorf("footer note: %d characters, maximum is %d", n, maxFooterText)
+		}
+	}
+	if notes > maxFooterInfo {
+		return fmt.Errorf("%d footer notes, maximum is %d", notes, maxFooterInfo)
+	}
+
+	if inv.Supplier == nil {
+		return nil
+	}
+	for _, id := range inv.Supplier.Identities {
+		if re, ok := registryCodeRegexps[id.Type]; ok && !re.MatchString(id.Code.String()) {
+			return fmt.Errorf("supplier %s identity '%s': invalid format", id.Type, id.Code)
+		}
+	}
+	return nil
+}
+
+// NewFooter builds the footer from the invoice notes with the footer source and
+// the supplier KRS, REGON and BDO identities and registry name. Returns nil when there is nothing
+// to report.
+func NewFooter(inv *bill.Invoice) *Footer {
+	footer := new(Footer)
+
+	for _, note := range inv.Notes {
+		if note.Src == NoteSrcFooter && len(footer.Info) < maxFooterInfo {
+			footer.Info = append(footer.Info, &FooterInfo{Text: note.Text})
+		}
+	}
+
+	if inv.Supplier != nil {
+		registry := &Registry{
+			FullName: truncate(inv.Supplier.Meta[MetaKeyRegistryName], maxRegistryName),
+		}
+		for _, id := range inv.Supplier.Identities {
+			switch id.Type {
+			case IdentityTypeKRS:
+				registry.KRS = id.Code.String()
+			case IdentityTypeREGON:
+				registry.REGON = id.Code.String()
+			case IdentityTypeBDO:
+				registry.BDO = id.Code.String()
+			}
+		}
+		if *registry != (Registry{}) {
+			footer.Registries = append(footer.Registries, registry)
+		}
+	}
+
+	if len(footer.Info) == 0 && len(footer.Registries) == 0 {
+		return nil
+	}
+
+	return footer
+}
+
+// parseFooter converts the KSeF footer into GOBL notes and supplier identities,
+// with the registry name in the supplier meta.
+func (d *Invoice) parseFooter(inv *bill.Invoice) {
+	if d.Footer == nil {
+		return
+	}
+
+	for _, info := range d.Footer.Info {
+		if info.Text == "" {
+			continue
+		}
+		inv.Notes = append(inv.Notes, &org.Note{
+			Key:  org.NoteKeyLegal,
+			Src:  NoteSrcFooter,
+			Text: info.Text,
+		})
+	}
+
+	if inv.Supplier == nil {
+		return
+	}
+	for _, r := range d.Footer.Registries {
+		if r.FullName != "" {
+			if inv.Supplier.Meta == nil {
+				inv.Supplier.Meta = make(cbc.Meta)
+			}
+			inv.Supplier.Meta[MetaKeyRegistryName] = r.FullName
+		}
+		for _, id := range []struct {
+			typ  cbc.Code
+			code string
+		}{
+			{IdentityTypeKRS, r.KRS},
+			{IdentityTypeREGON, r.REGON},
+			{IdentityTypeBDO, r.BDO},
+		} {
+			if id.code == "" {
+				continue
+			}
+			inv.Supplier.Identities = append(inv.Supplier.Identities, &org.Identity{
+				Type: id.typ,
+				Code: cbc.Code(id.code),
+			})
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package ksef_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/invopop/gobl"
+	ksef "github.com/invopop/gobl.ksef"
+	"github.com/invopop/gobl.ksef/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/org"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFooter(t *testing.T) {
+	t.Run("should return nil when there is nothing to report", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Supplier: &org.Party{
+				Identities: []*org.Identity{{Type: "OTHER", Code: "123"}},
+			},
+			Notes: []*org.Note{{Key: org.NoteKeyGeneral, Text: "Note"}},
+		}
+
+		assert.Nil(t, ksef.NewFooter(inv))
+	})
+
+	t.Run("should map supplier registry identities", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Supplier: &org.Party{
+				Identities: []*org.Identity{
+					{Type: ksef.IdentityTypeKRS, Code: "0000123456"},
+					{Type: ksef.IdentityTypeREGON, Code: "123456785"},
+					{Type: ksef.IdentityTypeBDO, Code: "000012345"},
+				},
+			},
+		}
+
+		footer := ksef.NewFooter(inv)
+		require.NotNil(t, footer)
+		assert.Empty(t, footer.Info)
+		assert.Equal(t, []*ksef.Registry{
+			{KRS: "0000123456", REGON: "123456785", BDO: "000012345"},
+		}, footer.Registries)
+	})
+
+	t.Run("should map up to three footer notes", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Notes: []*org.Note{
+				{Key: org.NoteKeyGeneral, Text: "Not in footer"},
+				{Key: org.NoteKeyLegal, Src: ksef.NoteSrcFooter, Text: "First"},
+				{Key: org.NoteKeyLegal, Src: ksef.NoteSrcFooter, Text: "Second"},
+				{Key: org.NoteKeyLegal, Src: ksef.NoteSrcFooter, Text: "Third"},
+				{Key: org.NoteKeyLegal, Src: ksef.NoteSrcFooter, Text: "Fourth"},
+			},
+		}
+
+		footer := ksef.NewFooter(inv)
+		require.NotNil(t, footer)
+		assert.Equal(t, []*ksef.FooterInfo{
+			{Text: "First"},
+			{Text: "Second"},
+			{Text: "Third"},
+		}, footer.Info)
+		assert.Empty(t, footer.Registries)
+	})
+
+	t.Run("should not repeat footer notes in additional description", func(t *testing.T) {
+		doc, err := test.BuildFAVATFrom("invoice-footer.json")
+		require.NoError(t, err)
+
+		require.Len(t, doc.Inv.AdditionalDescription, 1)
+		assert.Equal(t, "general", doc.Inv.AdditionalDescription[0].Key)
+		require.NotNil(t, doc.Footer)
+		assert.Len(t, doc.Footer.Info, 1)
+	})
+}
+
+func TestValidateFooter(t *testing.T) {
+	load := func(t *testing.T) (*gobl.Envelope, *bill.Invoice) {
+		t.Helper()
+		env, err := test.LoadTestEnvelope("invoice-footer.json")
+		require.NoError(t, err)
+		return env, env.Extract().(*bill.Invoice)
+	}
+
+	t.Run("rejects more than three footer notes", func(t *testing.T) {
+		env, inv := load(t)
+		for i := 0; i < 3; i++ {
+			inv.Notes = append(inv.Notes, &org.Note{Key: org.NoteKeyLegal, Src: ksef.NoteSrcFooter, Text: "Stopka"})
+		}
+
+		_, err := ksef.BuildFavat(env)
+		assert.ErrorContains(t, err, "4 footer notes, maximum is 3")
+	})
+
+	t.Run("rejects footer notes over 3500 characters", func(t *testing.T) {
+		env, inv := load(t)
+		inv.Notes = append(inv.Notes, &org.Note{Key: org.NoteKeyLegal, Src: ksef.NoteSrcFooter, Text: strings.Repeat("ż", 3501)})
+
+		_, err := ksef.BuildFavat(env)
+		assert.ErrorContains(t, err, "footer note: 3501 characters, maximum is 3500")
+	})
+
+	for _, tt := range []struct {
+		typ  cbc.Code
+		code cbc.Code
+	}{
+		{ksef.IdentityTypeKRS, "123456"},
+		{ksef.IdentityTypeREGON, "1234567890"},
+		{ksef.IdentityTypeBDO, "BDO-0001234"},
+	} {
+		t.Run("rejects invalid "+tt.typ.String()+" numbers", func(t *testing.T) {
+			env, inv := load(t)
+			inv.Supplier.Identities = append(inv.Supplier.Identities, &org.Identity{Type: tt.typ, Code: tt.code})
+
+			_, err := ksef.BuildFavat(env)
+			assert.ErrorContains(t, err, "supplier "+tt.typ.String()+" identity '"+tt.code.String()+"': invalid format")
+		})
+	}
+}
+
+func TestParseFooter(t *testing.T) {
+	t.Run("should parse footer into notes and supplier identities", func(t *testing.T) {
+		data, err := test.LoadOutputFile("invoice-footer.xml")
+		require.NoError(t, err)
+
+		env, err := ksef.ParseKSeF(data)
+		require.NoError(t, err)
+		inv, ok := env.Extract().(*bill.Invoice)
+		require.True(t, ok)
+
+		require.Len(t, inv.Supplier.Identities, 3)
+		assert.Equal(t, ksef.IdentityTypeKRS, inv.Supplier.Identities[0].Type)
+		assert.Equal(t, cbc.Code("0000123456"), inv.Supplier.Identities[0].Code)
+		assert.Equal(t, ksef.IdentityTypeREGON, inv.Supplier.Identities[1].Type)
+		assert.Equal(t, ksef.IdentityTypeBDO, inv.Supplier.Identities[2].Type)
+
+		require.Len(t, inv.Notes, 2)
+		assert.Empty(t, inv.Notes[0].Src)
+		assert.Equal(t, ksef.NoteSrcFooter, inv.Notes[1].Src)
+		assert.Equal(t, org.NoteKeyLegal, inv.Notes[1].Key)
+	})
+	t.Run("should keep the registry name in the supplier meta", func(t *testing.T) {
+		data, err := test.LoadOutputFile("invoice-footer.xml")
+		require.NoError(t, err)
+		xml := strings.Replace(string(data), "<KRS>", "<PelnaNazwa>Przykładowa Spółka z ograniczoną odpowiedzialnością</PelnaNazwa>\n      <KRS>", 1)
+
+		env, err := ksef.ParseKSeF([]byte(xml))
+		require.NoError(t, err)
+		inv := env.Extract().(*bill.Invoice)
+		assert.Equal(t, "Przykładowa Spółka z ograniczoną odpowiedzialnością", inv.Supplier.Meta[ksef.MetaKeyRegistryName])
+
+		doc, err := ksef.BuildFavat(env)
+		require.NoError(t, err)
+		require.Len(t, doc.Footer.Registries, 1)
+		assert.Equal(t, "Przykładowa Spółka z ograniczoną odpowiedzialnością", doc.Footer.Registries[0].FullName)
+	})
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/invopop/gobl/bill"
 	"github.com/invopop/gobl/cal"
 	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/head"
+	"github.com/invopop/gobl/num"
 	"github.com/invopop/gobl/org"
 	"github.com/invopop/gobl/tax"
 )
@@ -21,7 +23,6 @@ type Inv struct {
 	WarehouseDocuments                 []string                     `xml:"WZ,omitempty"`
 	CompletionDate                     string                       `xml:"P_6,omitempty"`
 	Period                             *InvoicePeriod               `xml:"OkresFa,omitempty"`
-	ExchangeRate                       string                       `xml:"KursWalutyZ,omitempty"`
 	StandardRateNetSale                string                       `xml:"P_13_1,omitempty"`
 	StandardRateTax                    string                       `xml:"P_14_1,omitempty"`
 	StandardRateTaxConvertedToPln      string                       `xml:"P_14_1W,omitempty"`
@@ -45,22 +46,27 @@ type Inv struct {
 	DomesticReverseChargeNetSale       string                       `xml:"P_13_10,omitempty"`
 	MarginNetSale                      string                       `xml:"P_13_11,omitempty"`
 	TotalAmountDue                     string                       `xml:"P_15"`
-	AmountBeforeCorrection             string                       `xml:"P_15ZK,omitempty"`
+	ExchangeRate                       string                       `xml:"KursWalutyZ,omitempty"`
 	Annotations                        *Annotations                 `xml:"Adnotacje"`
 	InvoiceType                        string                       `xml:"RodzajFaktury"`
 	CorrectionReason                   string                       `xml:"PrzyczynaKorekty,omitempty"`
 	CorrectionType                     string                       `xml:"TypKorekty,omitempty"`
 	CorrectedInv                       []*CorrectedInv              `xml:"DaneFaKorygowanej,omitempty"`
-	AdvanceInvoices                    []*AdvanceInvoiceRef         `xml:"FakturaZaliczkowa,omitempty"`
+	CorrectionPeriod                   string                       `xml:"OkresFaKorygowanej,omitempty"`
+	CorrectedInvoiceNo                 string                       `xml:"NrFaKorygowany,omitempty"`
+	CorrectedSeller                    *Seller                      `xml:"Podmiot1K,omitempty"`
+	CorrectedBuyers                    []*CorrectedBuyer            `xml:"Podmiot2K,omitempty"`
+	AmountBeforeCorrection             string                       `xml:"P_15ZK,omitempty"`
 	PartialAdvancePayments             []*PartialAdvancePayment     `xml:"ZaliczkaCzesciowa,omitempty"`
 	FP                                 int                          `xml:"FP,omitempty"`
 	TP                                 int                          `xml:"TP,omitempty"`
-	ExciseTaxRefund                    int                          `xml:"ZwrotAkcyzy,omitempty"`
 	AdditionalDescription              []*AdditionalDescriptionLine `xml:"DodatkowyOpis,omitempty"`
+	AdvanceInvoices                    []*AdvanceInvoiceRef         `xml:"FakturaZaliczkowa,omitempty"`
+	ExciseTaxRefund                    int                          `xml:"ZwrotAkcyzy,omitempty"`
 	Lines                              []*Line                      `xml:"FaWiersz,omitempty"` // empty for ZAL and KOR_ZAL, use Order instead
 	Settlement                         *Settlement                  `xml:"Rozliczenie,omitempty"`
-	TransactionConditions              *TransactionConditions       `xml:"WarunkiTransakcji,omitempty"`
 	Payment                            *Payment                     `xml:"Platnosc,omitempty"`
+	TransactionConditions              *TransactionConditions       `xml:"WarunkiTransakcji,omitempty"`
 	Order                              *Order                       `xml:"Zamowienie,omitempty"` // for ZAL and KOR_ZAL types
 }
 
@@ -145,111 +151,96 @@ type Order struct {
 	LineItems   []*OrderLine `xml:"ZamowienieWiersz,omitempty"`
 }
 
-// AdvanceInvoiceRef defines the XML structure for advance invoice reference
-type AdvanceInvoiceRef struct {
-	KSeFMarker           int    `xml:"NrKSeFZN,omitempty"`
-	AdvanceInvoiceNo     string `xml:"NrFaZaliczkowej,omitempty"`
-	KSeFAdvanceInvoiceNo string `xml:"NrKSeFFaZaliczkowej,omitempty"`
-}
-
-// PartialAdvancePayment defines the XML structure for partial advance payment (ZaliczkaCzesciowa)
-type PartialAdvancePayment struct {
-	PaymentDate          string `xml:"P_6Z"`
-	PaymentAmount        string `xml:"P_15Z"`
-	CurrencyExchangeRate string `xml:"KursWalutyZW,omitempty"`
+// NewOrder builds the order block of an advance invoice from the invoice
+// lines, which list the ordered goods or services. The order value includes
+// tax, and is the amount payable of the purchase order when the ordering
+// details have one, or else the invoice total with tax.
+func NewOrder(invoice *bill.Invoice) *Order {
+	return newOrder(invoice, indexLines(invoice.Lines))
 }
 
-// Settlement defines the XML structure for additional charges and deductions
-type Settlement struct {
-	Charges         []*ChargeOrDeduction `xml:"Obciazenia>Obciazenie,omitempty"`
-	TotalCharges    string               `xml:"Obciazenia>SumaObciazen,omitempty"`
-	Deductions      []*ChargeOrDeduction `xml:"Odliczenia>Odliczenie,omitempty"`
-	TotalDeductions string               `xml:"Odliczenia>SumaOdliczen,omitempty"`
-	AmountToPay     string               `xml:"DoZaplaty,omitempty"`
-	AmountToSettle  string               `xml:"DoRozliczenia,omitempty"`
-}
-
-// ChargeOrDeduction defines the XML structure for a single charge or deduction
-type ChargeOrDeduction struct {
-	Amount string `xml:"Kwota"`
-	Reason string `xml:"Powod"`
-}
-
-// TransactionConditions defines the XML structure for transaction conditions
-type TransactionConditions struct {
-	Contracts         []*Contract  `xml:"Umowy>Umowa,omitempty"`
-	Orders            []*OrderRef  `xml:"Zamowienia>Zamowienie,omitempty"`
-	BatchNumbers      []string     `xml:"NrPartiiTowaru,omitempty"`
-	DeliveryTerms     string       `xml:"WarunkiDostawy,omitempty"`
-	ContractRate      string       `xml:"KursUmowny,omitempty"`
-	ContractCurrency  string       `xml:"WalutaUmowna,omitempty"`
-	Transport         []*Transport `xml:"Transport,omitempty"`
-	IntermediaryParty int          `xml:"PodmiotPosredniczacy,omitempty"`
-}
-
-// Contract defines the XML structure for contract reference
-type Contract struct {
-	Date   string `xml:"DataUmowy"`
-	Number string `xml:"NrUmowy"`
-}
-
-// OrderRef defines the XML structure for order reference
-type OrderRef struct {
-	Date   string `xml:"DataZamowienia"`
-	Number string `xml:"NrZamowienia"`
+func newOrder(invoice *bill.Invoice, lines map[int]*bill.Line) *Order {
+	cu := invoice.Currency.Def().Subunits
+	order := &Order{
+		OrderAmount: orderValue(invoice).String(),
+		LineItems:   NewOrderLines(invoice.Lines, cu),
+	}
+	order.removeIncludedVAT(invoice, lines, cu)
+	return order
 }
 
-// Transport defines the XML structure for transport information
-type Transport struct {
-	TransportType        string     `xml:"RodzajTransportu,omitempty"`
-	OtherTransportType   int        `xml:"TransportInny,omitempty"`
-	OtherTransportDesc   string     `xml:"OpisInnegoTransportu,omitempty"`
-	Carrier              *Carrier   `xml:"Przewoznik,omitempty"`
-	TransportOrderNumber string     `xml:"NrZleceniaTransportu,omitempty"`
-	CargoType            string     `xml:"OpisLadunku,omitempty"`
-	OtherCargoType       int        `xml:"LadunekInny,omitempty"`
-	OtherCargoDesc       string     `xml:"OpisInnegoLadunku,omitempty"`
-	PackagingUnit        string     `xml:"JednostkaOpakowania,omitempty"`
-	TransportStartTime   string     `xml:"DataGodzRozpTransportu,omitempty"`
-	TransportEndTime     string     `xml:"DataGodzZakTransportu,omitempty"`
-	ShipFrom             *Address   `xml:"WysylkaZ,omitempty"`
-	ShipVia              []*Address `xml:"WysylkaPrzez,omitempty"`
-	ShipTo               *Address   `xml:"WysylkaDo,omitempty"`
+// orderValue returns the value of the order including tax, inverted on credit
+// notes like the invoice amounts.
+func orderValue(invoice *bill.Invoice) num.Amount {
+	if ref := purchaseOrder(invoice.Ordering); ref != nil {
+		if invoice.Type == bill.InvoiceTypeCreditNote {
+			return ref.Payable.Invert()
+		}
+		return *ref.Payable
+	}
+	return invoice.Totals.TotalWithTax
 }
 
-// Carrier defines the XML structure for carrier information
-type Carrier struct {
-	IdentificationData *Buyer   `xml:"DaneIdentyfikacyjne"`
-	Address            *Address `xml:"AdresPrzewoznika"`
+// purchaseOrder returns the first purchase order with an amount payable
+func purchaseOrder(ordering *bill.Ordering) *org.DocumentRef {
+	if ordering == nil {
+		return nil
+	}
+	for _, ref := range ordering.Purchases {
+		if ref.Payable != nil {
+			return ref
+		}
+	}
+	return nil
 }
 
 // NewFavatInv gets invoice data from GOBL invoice
 func NewFavatInv(invoice *bill.Invoice) *Inv {
-
+	lines := indexLines(invoice.Lines)
 	inv := &Inv{
 		CurrencyCode:     invoice.Currency.String(),
 		IssueDate:        invoice.IssueDate.String(),
 		Period:           newInvoicePeriod(invoice.Ordering),
+		CompletionDate:   newCompletionDate(invoice),
 		SequentialNumber: invoiceNumber(invoice.Series, invoice.Code),
 		Annotations:      newAnnotations(invoice),
-		Lines:            NewLines(invoice.Lines),
 		Payment:          NewPayment(invoice.Payment, invoice.Totals),
 	}
 
+	payable := invoice.Totals.Payable
 	if invoice.Totals.Due != nil {
-		inv.TotalAmountDue = invoice.Totals.Due.String()
-	} else {
-		inv.TotalAmountDue = invoice.Totals.Payable.String()
+		payable = *invoice.Totals.Due
 	}
 
+	// Charges and deductions outside the VAT base are reported in the
+	// settlement block, and excluded from the P_15 total.
+	inv.Settlement = NewSettlement(invoice.Charges, invoice.Discounts, payable)
+	charges, deductions := settlementTotals(invoice.Charges, invoice.Discounts, payable.Exp())
+	inv.TotalAmountDue = payable.Subtract(charges).Add(deductions).String()
+
 	if invoice.Tax != nil && invoice.Tax.Ext != nil {
 		inv.InvoiceType = invoice.Tax.Ext.Get(favat.ExtKeyInvoiceType).String()
 	}
 
-	inv.setTaxRates(invoice.Totals.Taxes)
+	// Advance invoices report the ordered goods or services instead of
+	// the invoice lines, and are issued for the advances received.
+	if invoice.HasTags(tax.TagPartial) {
+		inv.Order = newOrder(invoice, lines)
+		if invoice.Totals.Advances != nil {
+			inv.TotalAmountDue = invoice.Totals.Advances.String()
+		}
+	} else {
+		inv.Lines = NewLines(invoice.Lines)
+	}
+
+	inv.setTaxRates(invoicedTaxes(invoice))
+	inv.TransactionConditions = NewTransactionConditions(invoice)
 
 	if len(invoice.Notes) > 0 {
 		for _, note := range invoice.Notes {
+			if note.Src == NoteSrcFooter {
+				continue
+			}
 			inv.AdditionalDescription = append(inv.AdditionalDescription, &AdditionalDescriptionLine{
 				Key:   note.Key.String(),
 				Value: note.Text,
@@ -257,7 +248,13 @@ func NewFavatInv(invoice *bill.Invoice) *Inv {
 		}
 	}
 
-	if len(invoice.Preceding) > 0 {
+	inv.PartialAdvancePayments = NewPartialAdvancePayments(invoice)
+
+	// Settlement invoices refer to the advance invoices they settle
+	// instead of corrected invoices.
+	if inv.InvoiceType == "ROZ" {
+		inv.AdvanceInvoices = NewAdvanceInvoiceRefs(invoice)
+	} else if len(invoice.Preceding) > 0 {
 		if invoice.Preceding[0].Reason != "" {
 			inv.CorrectionReason = invoice.Preceding[0].Reason
 		}
@@ -267,8 +264,21 @@ func NewFavatInv(invoice *bill.Invoice) *Inv {
 		for _, prc := range invoice.Preceding {
 			inv.CorrectedInv = append(inv.CorrectedInv, NewCorrectedInv(prc))
 		}
+		inv.CorrectionPeriod = newCorrectionPeriod(invoice.Preceding)
+		inv.CorrectedInvoiceNo = newCorrectedInvoiceNo(invoice.Preceding)
+		inv.AmountBeforeCorrection = newAmountBeforeCorrection(invoice.Preceding)
+		inv.CorrectedSeller = NewCorrectedSeller(invoice)
+		inv.CorrectedBuyers = NewCorrectedBuyers(invoice)
+		if inv.CorrectedSeller != nil || len(inv.CorrectedBuyers) > 0 || inv.CorrectedInvoiceNo != "" {
+			inv.Lines = NewLines(withoutPlaceholders(invoice.Lines))
+		}
 	}
 
+	inv.setLineCompletionDates(lines)
+	inv.setLineVAT(invoice, lines)
+	inv.setGrossPrices(invoice)
+	inv.setExchangeRates(invoice, lines)
+
 	return inv
 }
 
@@ -290,6 +300,41 @@ func newInvoicePeriod(ordering *bill.Ordering) *InvoicePeriod {
 	}
 }
 
+// completionDate returns the date of the supply common to the whole invoice,
+// which is the operation date, or else the delivery date.
+func completionDate(invoice *bill.Invoice) *cal.Date {
+	if invoice.OperationDate != nil {
+		return invoice.OperationDate
+	}
+	if invoice.Delivery != nil {
+		return invoice.Delivery.Date
+	}
+	return nil
+}
+
+// newCompletionDate returns the date of the supply (P_6), unless the invoice
+// has a period (OkresFa), which ends on that date.
+func newCompletionDate(invoice *bill.Invoice) string {
+	date := completionDate(invoice)
+	if date == nil || newInvoicePeriod(invoice.Ordering) != nil {
+		return ""
+	}
+	return date.String()
+}
+
+// validateCompletionDate checks that the date of the supply of an invoice
+// with a period is its end date, as KSeF only accepts one of them.
+func validateCompletionDate(invoice *bill.Invoice) error {
+	date := completionDate(invoice)
+	if date == nil || invoice.Ordering == nil || invoice.Ordering.Period == nil {
+		return nil
+	}
+	if *date != invoice.Ordering.Period.End {
+		return fmt.Errorf("completion date %s conflicts with invoice period ending %s", date, invoice.Ordering.Period.End)
+	}
+	return nil
+}
+
 func (inv *Inv) setTaxRates(taxes *tax.Total) {
 	for _, cat := range taxes.Categories {
 		if cat.Code != tax.CategoryVAT {
@@ -354,6 +399,18 @@ func (inv *Inv) parseInvoiceData(goblInv *bill.Invoice) error {
 
 	goblInv.Code = cbc.Code(inv.SequentialNumber)
 
+	// Parse completion date, which is also the end of the invoice period
+	if inv.CompletionDate != "" {
+		date, err := parseDate(inv.CompletionDate)
+		if err != nil {
+			return fmt.Errorf("parsing completion date: %w", err)
+		}
+		if inv.Period != nil && inv.Period.EndDate != inv.CompletionDate {
+			return fmt.Errorf("completion date %s conflicts with invoice period ending %s", inv.CompletionDate, inv.Period.EndDate)
+		}
+		goblInv.OperationDate = &date
+	}
+
 	// Parse ordering period
 	if inv.Period != nil {
 		goblInv.Ordering = &bill.Ordering{
@@ -497,9 +554,40 @@ func (inv *Inv) parseInvoiceData(goblInv *bill.Invoice) error {
 					favat.ExtKeyEffectiveDate: cbc.Code(inv.CorrectionType),
 				}
 			}
+			if corr.KsefNumber != "" {
+				preceding.Stamps = []*head.Stamp{
+					{
+						Provider: favat.StampKSEFNumber,
+						Value:    corr.KsefNumber,
+					},
+				}
+			}
+			parseCorrectionPeriod(preceding, inv.CorrectionPeriod)
 
 			goblInv.Preceding = append(goblInv.Preceding, preceding)
 		}
+
+		// The wrong number fix and the amount before correction are set on
+		// the first corrected invoice
+		prc := goblInv.Preceding[0]
+		if inv.CorrectedInvoiceNo != "" {
+			if prc.Meta == nil {
+				prc.Meta = cbc.Meta{}
+			}
+			prc.Meta[MetaKeyCorrectedNumber] = inv.CorrectedInvoiceNo
+		}
+		if inv.AmountBeforeCorrection != "" {
+			amount, err := parseAmount(inv.AmountBeforeCorrection)
+			if err != nil {
+				return fmt.Errorf("parsing amount before correction: %w", err)
+			}
+			prc.Payable = &amount
+		}
+	}
+
+	// Parse transaction conditions
+	if err := inv.parseTransactionConditions(goblInv); err != nil {
+		return fmt.Errorf("parsing transaction conditions: %w", err)
 	}
 
 	return nil
@@ -543,10 +631,11 @@ func parseInvoiceType(invType string) (cbc.Key, []cbc.Key) {
 	}
 }
 
-// parseLines converts KSEF lines to GOBL lines.
+// parseLines converts KSEF lines, or the order lines of advance invoices,
+// to GOBL lines.
 func (inv *Inv) parseLines(goblInv *bill.Invoice) error {
 	if len(inv.Lines) == 0 {
-		return nil
+		return inv.parseOrderLines(goblInv)
 	}
 
 	goblInv.Lines = make([]*bill.Line, 0, len(inv.Lines))
@@ -559,6 +648,103 @@ func (inv *Inv) parseLines(goblInv *bill.Invoice) error {
 		goblInv.Lines = append(goblInv.Lines, line)
 	}
 
+	// Invoices with VAT per line have the amount on every taxed line
+	for _, l := range inv.Lines {
+		if l.VATAmount != "" {
+			if goblInv.Tax == nil {
+				goblInv.Tax = new(bill.Tax)
+			}
+			goblInv.Tax.Ext = goblInv.Tax.Ext.Set(ExtKeyLineVAT, "1")
+			break
+		}
+	}
+
+	// Gross prices include VAT, which GOBL then takes out of the line totals,
+	// except on margin scheme invoices, where VAT is not shown.
+	if inv.Lines[0].HasGrossPrices() {
+		if goblInv.Tax == nil {
+			goblInv.Tax = new(bill.Tax)
+		}
+		if goblInv.Tax.Ext.Get(favat.ExtKeyMarginScheme) == "" {
+			goblInv.Tax.PricesInclude = tax.CategoryVAT
+		}
+	}
+
+	return nil
+}
+
+func (inv *Inv) parseOrderLines(goblInv *bill.Invoice) error {
+	if inv.Order == nil || len(inv.Order.LineItems) == 0 {
+		return nil
+	}
+
+	goblInv.Lines = make([]*bill.Line, 0, len(inv.Order.LineItems))
+
+	for _, ksefLine := range inv.Order.LineItems {
+		line, err := ksefLine.ToGOBL()
+		if err != nil {
+			return fmt.Errorf("parsing order line %d: %w", ksefLine.LineNumber, err)
+		}
+		goblInv.Lines = append(goblInv.Lines, line)
+	}
+
+	return nil
+}
+
+// orderLinesTotal returns the value including tax of the order lines, and
+// whether all of them have their net and VAT amounts.
+func (o *Order) orderLinesTotal() (num.Amount, bool) {
+	var total num.Amount
+	for _, l := range o.LineItems {
+		if l.NetPriceTotal == "" || l.TaxValue == "" {
+			return num.Amount{}, false
+		}
+		for _, v := range []string{l.NetPriceTotal, l.TaxValue} {
+			amount, err := parseAmount(v)
+			if err != nil {
+				return num.Amount{}, false
+			}
+			total = total.MatchPrecision(amount).Add(amount)
+		}
+	}
+	return total, len(o.LineItems) > 0
+}
+
+// total returns the value including tax of the order lines, or the order
+// value when the lines do not have their amounts.
+func (o *Order) total() (num.Amount, error) {
+	if total, ok := o.orderLinesTotal(); ok {
+		return total, nil
+	}
+	value, err := parseAmount(o.OrderAmount)
+	if err != nil {
+		return num.Amount{}, fmt.Errorf("parsing order value: %w", err)
+	}
+	return value, nil
+}
+
+// parseOrderValue keeps the value of the order of advance invoices in the
+// amount payable of the first purchase order, when it differs from the value
+// of the order lines. The value is reported in the warnings when there is no
+// purchase order to keep it.
+func (d *Invoice) parseOrderValue(goblInv *bill.Invoice) error {
+	order := d.Inv.Order
+	if order == nil || order.OrderAmount == "" {
+		return nil
+	}
+	value, err := parseAmount(order.OrderAmount)
+	if err != nil {
+		return fmt.Errorf("parsing order value: %w", err)
+	}
+	if total, ok := order.orderLinesTotal(); !ok || total.Equals(value) {
+		return nil
+	}
+
+	if goblInv.Ordering == nil || len(goblInv.Ordering.Purchases) == 0 {
+		d.Warnings = append(d.Warnings, fmt.Sprintf("Zamowienie>WartoscZamowienia: order value %s differs from the order lines and has no purchase order to keep it", value))
+		return nil
+	}
+	goblInv.Ordering.Purchases[0].Payable = &value
 	return nil
 }
 
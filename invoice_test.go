@@ -1,11 +1,17 @@
 package ksef_test
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	ksef "github.com/invopop/gobl.ksef"
+	"github.com/invopop/gobl.ksef/test"
 	"github.com/invopop/gobl/addons/pl/favat"
 	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
 	"github.com/invopop/gobl/currency"
 	"github.com/invopop/gobl/l10n"
 	"github.com/invopop/gobl/num"
@@ -76,6 +82,65 @@ func TestNewFavatInv(t *testing.T) {
 		assert.Equal(t, "1", invoice.CorrectionType)
 	})
 
+	t.Run("sets correction period", func(t *testing.T) {
+		inv := baseInvoice()
+		inv.Preceding = []*org.DocumentRef{
+			{Code: "FV/001"},
+			{
+				Code: "FV/002",
+				Period: &cal.Period{
+					Start: cal.MakeDate(2026, 1, 1),
+					End:   cal.MakeDate(2026, 3, 31),
+				},
+			},
+		}
+
+		invoice := ksef.NewFavatInv(inv)
+
+		assert.Len(t, invoice.CorrectedInv, 2)
+		assert.Equal(t, "2026-01-01 - 2026-03-31", invoice.CorrectionPeriod)
+	})
+
+	t.Run("sets correction period from meta", func(t *testing.T) {
+		inv := baseInvoice()
+		inv.Preceding = []*org.DocumentRef{
+			{
+				Code: "FV/001",
+				Meta: cbc.Meta{ksef.MetaKeyCorrectionPeriod: "I kwartał 2026"},
+			},
+		}
+
+		invoice := ksef.NewFavatInv(inv)
+
+		assert.Equal(t, "I kwartał 2026", invoice.CorrectionPeriod)
+	})
+
+	t.Run("sets amount before correction", func(t *testing.T) {
+		inv := baseInvoice()
+		payable := num.MakeAmount(615000, 2)
+		inv.Preceding = []*org.DocumentRef{
+			{Code: "ZAL-001", Payable: &payable},
+		}
+
+		invoice := ksef.NewFavatInv(inv)
+
+		assert.Equal(t, "6150.00", invoice.AmountBeforeCorrection)
+	})
+
+	t.Run("sets corrected invoice number", func(t *testing.T) {
+		inv := baseInvoice()
+		inv.Preceding = []*org.DocumentRef{
+			{
+				Code: "FV/001",
+				Meta: cbc.Meta{ksef.MetaKeyCorrectedNumber: "FV/002"},
+			},
+		}
+
+		invoice := ksef.NewFavatInv(inv)
+
+		assert.Equal(t, "FV/002", invoice.CorrectedInvoiceNo)
+	})
+
 	t.Run("sets the self-billing annotation to false in non-self-billed invoices", func(t *testing.T) {
 		inv := baseInvoice()
 
@@ -225,4 +290,274 @@ func TestNewFavatInv(t *testing.T) {
 
 		assert.Equal(t, "25.00", invoice.TotalAmountDue)
 	})
+
+	t.Run("sets order instead of lines for advance invoices", func(t *testing.T) {
+		inv := baseInvoice()
+		inv.SetTags(tax.TagPartial)
+		inv.Totals.TotalWithTax = num.MakeAmount(12300, 2)
+		total := num.MakeAmount(10000, 2)
+		inv.Lines = []*bill.Line{
+			{
+				Index:    1,
+				Quantity: num.MakeAmount(1, 0),
+				Item:     &org.Item{Name: "Order Item", Price: &total},
+				Total:    &total,
+				Taxes: tax.Set{
+					&tax.Combo{
+						Category: tax.CategoryVAT,
+						Percent:  num.NewPercentage(23, 2),
+					},
+				},
+			},
+		}
+
+		invoice := ksef.NewFavatInv(inv)
+
+		assert.Empty(t, invoice.Lines)
+		require.NotNil(t, invoice.Order)
+		assert.Equal(t, "123.00", invoice.Order.OrderAmount)
+		require.Len(t, invoice.Order.LineItems, 1)
+		assert.Equal(t, "23.00", invoice.Order.LineItems[0].TaxValue)
+	})
+
+	t.Run("sets total amount due of advance invoices to the advances received", func(t *testing.T) {
+		inv := baseInvoice()
+		inv.SetTags(tax.TagPartial)
+		inv.Totals.TotalWithTax = num.MakeAmount(12300, 2)
+		inv.Totals.Payable = num.MakeAmount(12300, 2)
+		advances := num.MakeAmount(4000, 2)
+		due := num.MakeAmount(8300, 2)
+		inv.Totals.Advances = &advances
+		inv.Totals.Due = &due
+
+		invoice := ksef.NewFavatInv(inv)
+
+		assert.Equal(t, "40.00", invoice.TotalAmountDue)
+	})
+
+	t.Run("splits the advances received between the order tax rates", func(t *testing.T) {
+		inv := baseInvoice()
+		inv.SetTags(tax.TagPartial)
+		inv.Totals.TotalWithTax = num.MakeAmount(23100, 2)
+		inv.Totals.Payable = num.MakeAmount(23100, 2)
+		advances := num.MakeAmount(10000, 2)
+		inv.Totals.Advances = &advances
+		inv.Totals.Taxes = &tax.Total{
+			Categories: []*tax.CategoryTotal{
+				{
+					Code: tax.CategoryVAT,
+					Rates: []*tax.RateTotal{
+						{
+							Ext:     tax.Extensions{favat.ExtKeyTaxCategory: "1"},
+							Percent: num.NewPercentage(23, 2),
+							Base:    num.MakeAmount(10000, 2),
+							Amount:  num.MakeAmount(2300, 2),
+						},
+						{
+							Ext:     tax.Extensions{favat.ExtKeyTaxCategory: "2"},
+							Percent: num.NewPercentage(8, 2),
+							Base:    num.MakeAmount(10000, 2),
+							Amount:  num.MakeAmount(800, 2),
+						},
+					},
+				},
+			},
+		}
+
+		invoice := ksef.NewFavatInv(inv)
+
+		assert.Equal(t, "231.00", invoice.Order.OrderAmount)
+		assert.Equal(t, "43.29", invoice.StandardRateNetSale)
+		assert.Equal(t, "9.96", invoice.StandardRateTax)
+		assert.Equal(t, "43.29", invoice.ReducedRateNetSale)
+		assert.Equal(t, "3.46", invoice.ReducedRateTax)
+		assert.Equal(t, "100.00", invoice.TotalAmountDue)
+	})
+
+	t.Run("sets order value from the purchase order", func(t *testing.T) {
+		inv := baseInvoice()
+		inv.SetTags(tax.TagPartial)
+		inv.Totals.TotalWithTax = num.MakeAmount(12300, 2)
+		value := num.MakeAmount(50000, 2)
+		inv.Ordering = &bill.Ordering{
+			Purchases: []*org.DocumentRef{
+				{Code: "ZAM/1"},
+				{Code: "ZAM/2", Payable: &value},
+			},
+		}
+
+		invoice := ksef.NewFavatInv(inv)
+
+		require.NotNil(t, invoice.Order)
+		assert.Equal(t, "500.00", invoice.Order.OrderAmount)
+	})
+
+	t.Run("sets completion date from operation date", func(t *testing.T) {
+		inv := baseInvoice()
+		inv.IssueDate = cal.MakeDate(2026, 3, 10)
+		inv.OperationDate = cal.NewDate(2026, 3, 6)
+		inv.Delivery = &bill.DeliveryDetails{Date: cal.NewDate(2026, 3, 5)}
+
+		invoice := ksef.NewFavatInv(inv)
+
+		assert.Equal(t, "2026-03-06", invoice.CompletionDate)
+	})
+
+	t.Run("sets completion date from delivery date", func(t *testing.T) {
+		inv := baseInvoice()
+		inv.Delivery = &bill.DeliveryDetails{Date: cal.NewDate(2026, 3, 5)}
+
+		invoice := ksef.NewFavatInv(inv)
+
+		assert.Equal(t, "2026-03-05", invoice.CompletionDate)
+	})
+
+	t.Run("does not set completion date with invoice period", func(t *testing.T) {
+		inv := baseInvoice()
+		inv.OperationDate = cal.NewDate(2026, 3, 31)
+		inv.Ordering = &bill.Ordering{
+			Period: &cal.Period{Start: cal.MakeDate(2026, 3, 1), End: cal.MakeDate(2026, 3, 31)},
+		}
+
+		invoice := ksef.NewFavatInv(inv)
+
+		assert.Empty(t, invoice.CompletionDate)
+		require.NotNil(t, invoice.Period)
+		assert.Equal(t, "2026-03-31", invoice.Period.EndDate)
+	})
+
+	t.Run("sets line completion dates that differ from the invoice", func(t *testing.T) {
+		inv := baseInvoice()
+		inv.OperationDate = cal.NewDate(2026, 3, 6)
+		total := num.MakeAmount(10000, 2)
+		for i, day := range []int{2, 6} {
+			inv.Lines = append(inv.Lines, &bill.Line{
+				Index:    i + 1,
+				Quantity: num.MakeAmount(1, 0),
+				Item:     &org.Item{Name: "Delivery", Price: &total},
+				Period:   &cal.Period{Start: cal.MakeDate(2026, 3, day), End: cal.MakeDate(2026, 3, day)},
+				Total:    &total,
+			})
+		}
+
+		invoice := ksef.NewFavatInv(inv)
+
+		require.Len(t, invoice.Lines, 2)
+		assert.Equal(t, "2026-03-02", invoice.Lines[0].CompletionDate)
+		assert.Empty(t, invoice.Lines[1].CompletionDate)
+	})
+}
+
+func TestCompletionDate(t *testing.T) {
+	t.Run("rejects operation date outside the invoice period", func(t *testing.T) {
+		env, err := test.LoadTestEnvelope("invoice-standard.json")
+		require.NoError(t, err)
+		inv := env.Extract().(*bill.Invoice)
+		inv.OperationDate = cal.NewDate(2026, 1, 15)
+		inv.Ordering = &bill.Ordering{
+			Period: &cal.Period{Start: cal.MakeDate(2026, 1, 1), End: cal.MakeDate(2026, 1, 31)},
+		}
+
+		_, err = ksef.BuildFavat(env)
+		assert.ErrorContains(t, err, "completion date 2026-01-15 conflicts with invoice period ending 2026-01-31")
+	})
+
+	load := func(t *testing.T, dates string) (*bill.Invoice, error) {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", "invoice-multiple-deliveries.xml"))
+		require.NoError(t, err)
+		data = []byte(strings.Replace(string(data), "</P_2>", "</P_2>"+dates, 1))
+		env, err := ksef.ParseKSeF(data)
+		if err != nil {
+			return nil, err
+		}
+		return env.Extract().(*bill.Invoice), nil
+	}
+
+	t.Run("parses completion dates", func(t *testing.T) {
+		inv, err := load(t, "<P_6>2026-03-06</P_6>")
+		require.NoError(t, err)
+
+		require.NotNil(t, inv.OperationDate)
+		assert.Equal(t, "2026-03-06", inv.OperationDate.String())
+		require.Len(t, inv.Lines, 3)
+		require.NotNil(t, inv.Lines[0].Period)
+		assert.Equal(t, "2026-03-02", inv.Lines[0].Period.End.String())
+	})
+
+	t.Run("rejects completion date conflicting with invoice period", func(t *testing.T) {
+		_, err := load(t, "<P_6>2026-03-06</P_6><OkresFa><P_6_Od>2026-03-01</P_6_Od><P_6_Do>2026-03-31</P_6_Do></OkresFa>")
+		assert.ErrorContains(t, err, "completion date 2026-03-06 conflicts with invoice period ending 2026-03-31")
+	})
+}
+
+func TestParseOrderValue(t *testing.T) {
+	load := func(t *testing.T, orders string) (*bill.Invoice, []string) {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", "invoice-prepayment.xml"))
+		require.NoError(t, err)
+		xml := strings.Replace(string(data), "<WartoscZamowienia>6150.00</WartoscZamowienia>", "<WartoscZamowienia>12300.00</WartoscZamowienia>", 1)
+		xml = strings.Replace(xml, "<Zamowienie>", orders+"<Zamowienie>", 1)
+
+		var warnings []string
+		env, err := ksef.ParseKSeF([]byte(xml), ksef.WithWarningHandler(func(w string) {
+			warnings = append(warnings, w)
+		}))
+		require.NoError(t, err)
+		return env.Extract().(*bill.Invoice), warnings
+	}
+
+	t.Run("keeps order value in the purchase order", func(t *testing.T) {
+		inv, warnings := load(t, "<WarunkiTransakcji><Zamowienia><DataZamowienia>2026-01-05</DataZamowienia><NrZamowienia>ZAM/1</NrZamowienia></Zamowienia></WarunkiTransakcji>")
+
+		assert.Empty(t, warnings)
+		require.NotNil(t, inv.Ordering)
+		require.Len(t, inv.Ordering.Purchases, 1)
+		require.NotNil(t, inv.Ordering.Purchases[0].Payable)
+		assert.Equal(t, "12300.00", inv.Ordering.Purchases[0].Payable.String())
+		assert.Equal(t, "6150.00", inv.Totals.TotalWithTax.String())
+	})
+
+	t.Run("reports order value without purchase order", func(t *testing.T) {
+		_, warnings := load(t, "")
+
+		assert.Equal(t, []string{"Zamowienie>WartoscZamowienia: order value 12300.00 differs from the order lines and has no purchase order to keep it"}, warnings)
+	})
+}
+
+func TestParseAdvanceReceived(t *testing.T) {
+	load := func(t *testing.T) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", "invoice-prepayment.xml"))
+		require.NoError(t, err)
+		xml := string(data)
+		start := strings.Index(xml, "<Platnosc>")
+		end := strings.Index(xml, "</Platnosc>") + len("</Platnosc>")
+		return xml[:start] + xml[end:]
+	}
+
+	t.Run("adds the amount received as an advance", func(t *testing.T) {
+		env, err := ksef.ParseKSeF([]byte(load(t)))
+		require.NoError(t, err)
+		inv := env.Extract().(*bill.Invoice)
+
+		require.NotNil(t, inv.Payment)
+		require.Len(t, inv.Payment.Advances, 1)
+		adv := inv.Payment.Advances[0]
+		assert.Equal(t, "3075.00", adv.Amount.String())
+		assert.Equal(t, inv.IssueDate, *adv.Date)
+		assert.Equal(t, "6150.00", inv.Totals.Payable.String())
+		assert.Equal(t, "3075.00", inv.Totals.Due.String())
+	})
+
+	t.Run("adds no advance when the whole order is received", func(t *testing.T) {
+		xml := strings.Replace(load(t), "<P_15>3075.00</P_15>", "<P_15>6150.00</P_15>", 1)
+
+		env, err := ksef.ParseKSeF([]byte(xml))
+		require.NoError(t, err)
+		inv := env.Extract().(*bill.Invoice)
+
+		assert.Nil(t, inv.Payment)
+		assert.Equal(t, "6150.00", inv.Totals.Payable.String())
+	})
 }
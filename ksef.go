@@ -11,6 +11,7 @@ import (
 	"github.com/invopop/gobl/bill"
 	"github.com/invopop/gobl/cbc"
 	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/org"
 	"github.com/invopop/gobl/tax"
 )
 
@@ -25,18 +26,62 @@ const (
 // Invoice is a pseudo-model for containing the XML document being created
 type Invoice struct {
 	XMLName      xml.Name
-	XSINamespace string        `xml:"xmlns:xsi,attr"`
-	XSDNamespace string        `xml:"xmlns:xsd,attr"`
-	XMLNamespace string        `xml:"xmlns,attr"`
-	Header       *Header       `xml:"Naglowek"`
-	Seller       *Seller       `xml:"Podmiot1"`
-	Buyer        *Buyer        `xml:"Podmiot2"`
-	ThirdParties []*ThirdParty `xml:"Podmiot3,omitempty"` // third party (up to 100)
-	Inv          *Inv          `xml:"Fa"`
+	XSINamespace string            `xml:"xmlns:xsi,attr"`
+	XSDNamespace string            `xml:"xmlns:xsd,attr"`
+	XMLNamespace string            `xml:"xmlns,attr"`
+	Header       *Header           `xml:"Naglowek"`
+	Seller       *Seller           `xml:"Podmiot1"`
+	Buyer        *Buyer            `xml:"Podmiot2"`
+	ThirdParties []*ThirdParty     `xml:"Podmiot3,omitempty"` // third party (up to 100)
+	Authorized   *AuthorizedEntity `xml:"PodmiotUpowazniony,omitempty"`
+	Inv          *Inv              `xml:"Fa"`
+	Footer       *Footer           `xml:"Stopka,omitempty"`
+	Attachment   *Attachment       `xml:"Zalacznik,omitempty"`
+
+	// Warnings collected while converting the document to GOBL, for data
+	// that could only be partially mapped
+	Warnings []string `xml:"-"`
+}
+
+// BuildOptFunc defines function for customizing the conversion to FA_VAT
+type BuildOptFunc func(*buildOpts)
+
+// buildOpts defines the conversion parameters
+type buildOpts struct {
+	exchangeRates ExchangeRateProvider // Provides the missing exchange rates to PLN
+}
+
+// WithExchangeRateProvider sets the provider of the exchange rates to PLN
+// missing from invoices in foreign currencies
+func WithExchangeRateProvider(provider ExchangeRateProvider) BuildOptFunc {
+	return func(o *buildOpts) {
+		o.exchangeRates = provider
+	}
+}
+
+// ParseOptFunc defines function for customizing the conversion from FA_VAT
+type ParseOptFunc func(*parseOpts)
+
+// parseOpts defines the parsing parameters
+type parseOpts struct {
+	warningHandler func(string) // Receives the warnings of the conversion
+}
+
+// WithWarningHandler sets the function receiving the warnings of the
+// conversion to GOBL, such as addresses kept as a single street line
+func WithWarningHandler(fn func(warning string)) ParseOptFunc {
+	return func(o *parseOpts) {
+		o.warningHandler = fn
+	}
 }
 
 // BuildFavat converts a GOBL envelope into a KSeF FA_VAT invoice document.
-func BuildFavat(env *gobl.Envelope) (*Invoice, error) {
+func BuildFavat(env *gobl.Envelope, opts ...BuildOptFunc) (*Invoice, error) {
+	o := buildOpts{}
+	for _, fn := range opts {
+		fn(&o)
+	}
+
 	inv, ok := env.Extract().(*bill.Invoice)
 	if !ok {
 		return nil, fmt.Errorf("invalid type %T", env.Document)
@@ -46,6 +91,44 @@ func BuildFavat(env *gobl.Envelope) (*Invoice, error) {
 		return nil, fmt.Errorf("invoice does not have the FA_VAT v3 addon")
 	}
 
+	if err := validateLineCodes(inv); err != nil {
+		return nil, err
+	}
+
+	if err := validateCompletionDate(inv); err != nil {
+		return nil, err
+	}
+
+	if err := validateTransport(inv); err != nil {
+		return nil, err
+	}
+
+	if err := validateSettlement(inv); err != nil {
+		return nil, err
+	}
+
+	if err := validatePartialAdvancePayments(inv); err != nil {
+		return nil, err
+	}
+
+	if err := validateThirdParties(inv); err != nil {
+		return nil, err
+	}
+
+	if err := validateTransactionConditions(inv); err != nil {
+		return nil, err
+	}
+
+	if err := validateFooter(inv); err != nil {
+		return nil, err
+	}
+
+	if o.exchangeRates != nil {
+		if err := addProvidedExchangeRates(inv, o.exchangeRates); err != nil {
+			return nil, fmt.Errorf("adding exchange rates: %w", err)
+		}
+	}
+
 	if inv.Type == bill.InvoiceTypeCreditNote {
 		// In KSEF credit notes become corrective invoices,
 		// which require negative totals.
@@ -54,6 +137,16 @@ func BuildFavat(env *gobl.Envelope) (*Invoice, error) {
 		}
 	}
 
+	authorized, err := NewAuthorizedEntity(inv)
+	if err != nil {
+		return nil, err
+	}
+
+	attachment, err := NewAttachment(inv)
+	if err != nil {
+		return nil, err
+	}
+
 	invoice := &Invoice{
 		XMLName:      xml.Name{Local: RootElementName},
 		XSINamespace: XSINamespace,
@@ -64,7 +157,15 @@ func BuildFavat(env *gobl.Envelope) (*Invoice, error) {
 		Seller:       NewFavatSeller(inv.Supplier),
 		Buyer:        NewFavatBuyer(inv.Customer),
 		ThirdParties: NewThirdParties(inv),
+		Authorized:   authorized,
 		Inv:          NewFavatInv(inv),
+		Footer:       NewFooter(inv),
+		Attachment:   attachment,
+	}
+
+	// Link the buyer with its data before correction
+	if len(invoice.Inv.CorrectedBuyers) > 0 {
+		invoice.Buyer.BuyerID = buyerLinkID
 	}
 
 	return invoice, nil
@@ -81,7 +182,12 @@ func (d *Invoice) Bytes() ([]byte, error) {
 }
 
 // ParseKSeF converts a KSeF FA_VAT XML document into a GOBL envelope.
-func ParseKSeF(xmlData []byte) (*gobl.Envelope, error) {
+func ParseKSeF(xmlData []byte, opts ...ParseOptFunc) (*gobl.Envelope, error) {
+	o := parseOpts{}
+	for _, fn := range opts {
+		fn(&o)
+	}
+
 	var doc Invoice
 	if err := xml.Unmarshal(xmlData, &doc); err != nil {
 		return nil, fmt.Errorf("unmarshaling XML: %w", err)
@@ -91,6 +197,11 @@ func ParseKSeF(xmlData []byte) (*gobl.Envelope, error) {
 	if err != nil {
 		return nil, fmt.Errorf("converting to GOBL: %w", err)
 	}
+	if o.warningHandler != nil {
+		for _, w := range doc.Warnings {
+			o.warningHandler(w)
+		}
+	}
 
 	env, err := gobl.Envelop(inv)
 	if err != nil {
@@ -100,7 +211,8 @@ func ParseKSeF(xmlData []byte) (*gobl.Envelope, error) {
 	return env, nil
 }
 
-// ToGOBL converts the KSeF Invoice to a GOBL invoice.
+// ToGOBL converts the KSeF Invoice to a GOBL invoice. Data that could only
+// be partially mapped is reported in the Warnings.
 func (d *Invoice) ToGOBL() (*bill.Invoice, error) {
 	if d.Inv == nil {
 		return nil, fmt.Errorf("missing invoice data")
@@ -116,26 +228,111 @@ func (d *Invoice) ToGOBL() (*bill.Invoice, error) {
 	}
 
 	// Parse parties
+	d.Warnings = nil
 	d.parseParties(inv)
+	d.Warnings = append(d.Warnings, d.addressWarnings()...)
+
+	// Parse party data before correction into the preceding document
+	d.parseCorrectedParties(inv)
+
+	// Parse footer notes and supplier registry numbers
+	d.parseFooter(inv)
+
+	// Parse attachment into complements
+	if err := d.parseAttachment(inv); err != nil {
+		return nil, err
+	}
+
+	// Parse transport details, which may complete the delivery receiver
+	if err := d.parseTransport(inv); err != nil {
+		return nil, fmt.Errorf("parsing transport: %w", err)
+	}
 
 	// Parse lines
 	if err := d.Inv.parseLines(inv); err != nil {
 		return nil, err
 	}
 
+	// Parse the order value of advance invoices given apart from the lines
+	if err := d.parseOrderValue(inv); err != nil {
+		return nil, err
+	}
+
+	// Parse exchange rates to PLN of invoices in foreign currencies
+	if err := d.Inv.parseExchangeRates(inv); err != nil {
+		return nil, err
+	}
+
+	// Parse rebates of corrections for a period with no lines
+	if err := d.Inv.parseRebates(inv); err != nil {
+		return nil, err
+	}
+
 	// Parse payment
 	if err := d.Inv.parsePayment(inv); err != nil {
 		return nil, err
 	}
 
+	// Parse partial advance payments received on advance invoices
+	if err := d.Inv.parsePartialAdvancePayments(inv); err != nil {
+		return nil, err
+	}
+
+	// Parse the amount received on advance invoices without partial payments
+	if err := d.Inv.parseAdvanceReceived(inv); err != nil {
+		return nil, err
+	}
+
+	// Parse advance invoices settled by the invoice
+	d.parseAdvanceInvoices(inv)
+
+	// Parse settlement charges and deductions
+	if err := d.Inv.parseSettlement(inv); err != nil {
+		return nil, err
+	}
+
 	// Calculate totals and adjust for rounding if needed
-	if err := AdjustRounding(inv, d.Inv.TotalAmountDue); err != nil {
+	amountToPay, err := d.Inv.amountToPay()
+	if err != nil {
+		return nil, err
+	}
+	orderDue, err := d.Inv.orderAmountDue(inv)
+	if err != nil {
+		return nil, err
+	}
+	if orderDue != "" {
+		amountToPay = orderDue
+	}
+	lineVAT, err := d.Inv.lineVAT()
+	if err != nil {
+		return nil, err
+	}
+	if lineVAT != nil {
+		err = AdjustLineRounding(inv, amountToPay, *lineVAT)
+	} else {
+		err = AdjustRounding(inv, amountToPay)
+	}
+	if err != nil {
 		return nil, err
 	}
 
 	return inv, nil
 }
 
+// truncate cuts the text to the maximum number of characters
+func truncate(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit])
+}
+
+// isCode reports whether the text can be kept as a GOBL code as it is.
+func isCode(text string) bool {
+	return cbc.Code(text).Validate() == nil
+}
+
 func parseCurrency(code string) cbc.Code {
 	if code == "" {
 		return "PLN"
@@ -155,29 +352,110 @@ func (d *Invoice) parseParties(inv *bill.Invoice) {
 		inv.Customer = d.Buyer.ToGOBL()
 	}
 
+	// Parse authorized entity (PodmiotUpowazniony)
+	if d.Authorized != nil {
+		invoiceOrdering(inv).Issuer = d.Authorized.ToGOBL()
+	}
+
 	// Parse third parties (Podmiot3)
-	if len(d.ThirdParties) > 0 {
-		for _, tp := range d.ThirdParties {
-			// Third parties can add identities to supplier or customer
+	d.parseThirdParties(inv)
+}
+
+// parseThirdParties converts KSEF third parties into the invoice parties
+// matching their roles. JST and VAT group members are added to the
+// identities of the supplier or customer. Other roles, and roles whose party
+// is already taken, use the free ordering buyer or seller with the role
+// extension, or are kept as customer identities with a warning.
+func (d *Invoice) parseThirdParties(inv *bill.Invoice) {
+	var pending []*ThirdParty
+	for _, tp := range d.ThirdParties {
+		if tp.isIdentityRole() {
 			identity := tp.toIdentity()
-			if identity != nil {
-				// Determine if this third party belongs to supplier or customer
-				// based on role codes
-				if tp.Role != "" {
-					role := tp.Role
-					switch role {
-					case "7", "9": // JST issuer, GV issuer
-						if inv.Supplier != nil {
-							inv.Supplier.Identities = append(inv.Supplier.Identities, identity)
-						}
-					case "8", "10": // JST recipient, GV recipient
-						if inv.Customer != nil {
-							inv.Customer.Identities = append(inv.Customer.Identities, identity)
-						}
-					}
+			if identity == nil {
+				continue
+			}
+			switch tp.Role {
+			case "7", "9": // JST issuer, GV issuer
+				if inv.Supplier != nil {
+					inv.Supplier.Identities = append(inv.Supplier.Identities, identity)
+				}
+			case "8", "10": // JST recipient, GV recipient
+				if inv.Customer != nil {
+					inv.Customer.Identities = append(inv.Customer.Identities, identity)
 				}
 			}
+			continue
+		}
+
+		slot := thirdPartySlot(inv, tp.Role)
+		if slot == nil || *slot != nil {
+			pending = append(pending, tp)
+			continue
+		}
+		*slot = tp.ToGOBL()
+	}
+
+	for _, tp := range pending {
+		// Additional buyers and payers prefer the ordering buyer
+		ordering := invoiceOrdering(inv)
+		slots := []**org.Party{&ordering.Seller, &ordering.Buyer}
+		if tp.Role == thirdPartyRoleBuyer || tp.Role == thirdPartyRolePayer {
+			slots = []**org.Party{&ordering.Buyer, &ordering.Seller}
+		}
+		var slot **org.Party
+		for _, sl := range slots {
+			if *sl == nil {
+				slot = sl
+				break
+			}
+		}
+		if slot != nil {
+			party := tp.ToGOBL()
+			if tp.Role != "" {
+				party.Ext = party.Ext.Set(favat.ExtKeyThirdPartyRole, cbc.Code(tp.Role))
+			}
+			*slot = party
+			continue
+		}
+
+		identity := tp.toIdentity()
+		if identity == nil || inv.Customer == nil {
+			d.Warnings = append(d.Warnings, fmt.Sprintf("Podmiot3: third party '%s' has no matching party and was dropped", tp.Name))
+			continue
 		}
+		identity.Description = tp.Name
+		inv.Customer.Identities = append(inv.Customer.Identities, identity)
+		d.Warnings = append(d.Warnings, fmt.Sprintf("Podmiot3: third party '%s' kept as a customer identity", tp.Name))
 	}
+}
 
+// thirdPartySlot returns the invoice party for the third party role, if the
+// role has one.
+func thirdPartySlot(inv *bill.Invoice, role string) **org.Party {
+	switch role {
+	case thirdPartyRoleFactor:
+		if inv.Payment == nil {
+			inv.Payment = new(bill.PaymentDetails)
+		}
+		return &inv.Payment.Payee
+	case thirdPartyRoleRecipient:
+		if inv.Delivery == nil {
+			inv.Delivery = new(bill.DeliveryDetails)
+		}
+		return &inv.Delivery.Receiver
+	case thirdPartyRoleOriginal:
+		return &invoiceOrdering(inv).Seller
+	case thirdPartyRoleIssuer:
+		return &invoiceOrdering(inv).Issuer
+	case thirdPartyRolePayer:
+		return &invoiceOrdering(inv).Buyer
+	}
+	return nil
+}
+
+func invoiceOrdering(inv *bill.Invoice) *bill.Ordering {
+	if inv.Ordering == nil {
+		inv.Ordering = new(bill.Ordering)
+	}
+	return inv.Ordering
 }
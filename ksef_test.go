@@ -3,7 +3,10 @@ package ksef_test
 import (
 	"testing"
 
+	ksef "github.com/invopop/gobl.ksef"
 	"github.com/invopop/gobl.ksef/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/tax"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -136,4 +139,35 @@ func TestBuildFAVAT(t *testing.T) {
 
 		test.ValidateAgainstFA3Schema(t, data)
 	})
+
+	t.Run("should generate valid invoice with GTU and procedure codes", func(t *testing.T) {
+		doc, err := test.BuildFAVATFrom("invoice-gtu-procedure.json")
+		require.NoError(t, err)
+
+		data, err := doc.Bytes()
+		require.NoError(t, err)
+
+		test.ValidateAgainstFA3Schema(t, data)
+	})
+
+	t.Run("should reject invalid GTU codes", func(t *testing.T) {
+		env, err := test.LoadTestEnvelope("invoice-gtu-procedure.json")
+		require.NoError(t, err)
+		inv := env.Extract().(*bill.Invoice)
+		inv.Lines[0].Item.Ext[ksef.ExtKeyGTU] = "GTU_14"
+
+		_, err = ksef.BuildFavat(env)
+		assert.ErrorContains(t, err, "line 1: invalid pl-ksef-gtu code 'GTU_14'")
+	})
+
+	t.Run("should reject import procedures on advance invoices", func(t *testing.T) {
+		env, err := test.LoadTestEnvelope("invoice-gtu-procedure.json")
+		require.NoError(t, err)
+		inv := env.Extract().(*bill.Invoice)
+		inv.SetTags(tax.TagPartial)
+		inv.Lines[1].Ext[ksef.ExtKeyProcedure] = "I_42"
+
+		_, err = ksef.BuildFavat(env)
+		assert.ErrorContains(t, err, "line 2: procedure 'I_42' not allowed on advance invoices")
+	})
 }
@@ -1,14 +1,47 @@
 package ksef
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/invopop/gobl/addons/pl/favat"
 	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
 	"github.com/invopop/gobl/cbc"
 	"github.com/invopop/gobl/num"
 	"github.com/invopop/gobl/org"
 	"github.com/invopop/gobl/tax"
+	"github.com/invopop/gobl/uuid"
+)
+
+// Identity keys of the item classifications reported on lines, together with
+// org.IdentityKeyGTIN
+const (
+	// IdentityKeyPKWiU identifies the code of the Polish Classification of
+	// Products and Services (PKWiU).
+	IdentityKeyPKWiU cbc.Key = "pkwiu"
+	// IdentityKeyCN identifies the code of the Combined Nomenclature (CN).
+	IdentityKeyCN cbc.Key = "cn"
+	// IdentityKeyPKOB identifies the code of the Polish Classification of
+	// Types of Constructions (PKOB).
+	IdentityKeyPKOB cbc.Key = "pkob"
+)
+
+// Meta keys of the item holding the line identifiers that cannot be kept as
+// a UUID or a code
+const (
+	// MetaKeyLineID holds a unique line identifier (UU_ID) that is not a UUID.
+	MetaKeyLineID cbc.Key = "line-id"
+	// MetaKeyIndex holds an internal item code (Indeks) that is not a valid
+	// code.
+	MetaKeyIndex cbc.Key = "index"
+)
+
+// Maximum lengths of the line identifiers; the GTIN is limited to 20
+// characters and the rest to 50
+const (
+	maxLineIdentifier = 50
+	maxGTIN           = 20
 )
 
 // Line defines the XML structure for KSeF item line (element type FaWiersz, for VAT and KOR type invoices)
@@ -70,10 +103,85 @@ func newLine(line *bill.Line) *Line {
 			l.VATRate = vatRate(tc)
 		}
 	}
+	l.UniqueID, l.InternalCode, l.GTIN, l.PKWiU, l.CN, l.PKOB = lineIdentifiers(line)
+	l.SpecialGoodsCode = lineExt(line, ExtKeyGTU).String()
+	l.Procedure = lineExt(line, ExtKeyProcedure).String()
+	// Lines before correction are reported with their original amounts
+	if isBeforeCorrection(line) {
+		l.BeforeCorrectionMarker = 1
+		l.Quantity = line.Quantity.Negate().String()
+		l.NetPriceTotal = line.Total.Negate().String()
+	}
 
 	return l
 }
 
+func isBeforeCorrection(line *bill.Line) bool {
+	return line.Ext.Get(ExtKeyBeforeCorrection) == "1"
+}
+
+// lineIdentifiers returns the UUID of the line, together with the reference
+// and classification codes of its item, truncated to their maximum lengths.
+// Identifiers that could not be kept as a UUID or a code when parsed are
+// taken from the item meta.
+func lineIdentifiers(line *bill.Line) (uid, ref, gtin, pkwiu, cn, pkob string) {
+	if !line.UUID.IsZero() {
+		uid = line.UUID.String()
+	}
+	if line.Item == nil {
+		return
+	}
+	ref = line.Item.Ref.String()
+	if uid == "" {
+		uid = line.Item.Meta[MetaKeyLineID]
+	}
+	if ref == "" {
+		ref = line.Item.Meta[MetaKeyIndex]
+	}
+	return truncate(uid, maxLineIdentifier),
+		truncate(ref, maxLineIdentifier),
+		truncate(itemIdentity(line.Item, org.IdentityKeyGTIN), maxGTIN),
+		truncate(itemIdentity(line.Item, IdentityKeyPKWiU), maxLineIdentifier),
+		truncate(itemIdentity(line.Item, IdentityKeyCN), maxLineIdentifier),
+		truncate(itemIdentity(line.Item, IdentityKeyPKOB), maxLineIdentifier)
+}
+
+func itemIdentity(item *org.Item, key cbc.Key) string {
+	if id := org.IdentityForKey(item.Identities, key); id != nil {
+		return id.Code.String()
+	}
+	return ""
+}
+
+// lineExt returns the extension code set on the line, or else on its item.
+func lineExt(line *bill.Line, key cbc.Key) cbc.Code {
+	if code := line.Ext.Get(key); code != "" {
+		return code
+	}
+	if line.Item != nil {
+		return line.Item.Ext.Get(key)
+	}
+	return ""
+}
+
+// validateLineCodes checks the GTU and procedure codes of the lines, as order
+// lines of advance invoices do not accept all the procedures.
+func validateLineCodes(inv *bill.Invoice) error {
+	for _, line := range inv.Lines {
+		for _, key := range []cbc.Key{ExtKeyGTU, ExtKeyProcedure} {
+			code := lineExt(line, key)
+			if code != "" && !tax.ExtensionForKey(key).HasCode(code) {
+				return fmt.Errorf("line %d: invalid %s code '%s'", line.Index, key, code)
+			}
+		}
+		code := lineExt(line, ExtKeyProcedure)
+		if code != "" && inv.HasTags(tax.TagPartial) && !code.In(orderLineProcedures...) {
+			return fmt.Errorf("line %d: procedure '%s' not allowed on advance invoices", line.Index, code)
+		}
+	}
+	return nil
+}
+
 // vatRate returns the VAT rate string and OSS tax rate string for a tax combo
 // based on the tax category extension
 func vatRate(tc *tax.Combo) string {
@@ -115,7 +223,15 @@ func unitDiscount(line *bill.Line) string {
 		amount = amount.Add(discount.Amount)
 	}
 
-	discount := amount.Divide(line.Quantity)
+	// Lines before correction are reported with their original amounts, so
+	// their discounts are negated back together with the quantity
+	quantity := line.Quantity
+	if isBeforeCorrection(line) {
+		amount = amount.Negate()
+		quantity = quantity.Negate()
+	}
+
+	discount := amount.Divide(quantity)
 
 	return discount.String()
 }
@@ -152,18 +268,211 @@ func newOrderLine(line *bill.Line, cu uint32) *OrderLine {
 		NetUnitPrice:  line.Item.Price.String(),
 		Quantity:      line.Quantity.String(),
 		NetPriceTotal: line.Total.String(),
+
+		SpecialGoodsCode: lineExt(line, ExtKeyGTU).String(),
+		Procedure:        lineExt(line, ExtKeyProcedure).String(),
+	}
+	l.UniqueID, l.InternalCode, l.GTIN, l.PKWiU, l.CN, l.PKOB = lineIdentifiers(line)
+	total := line.Total
+	// Lines before correction are reported with their original amounts
+	if isBeforeCorrection(line) && total != nil {
+		l.BeforeCorrectionMarker = 1
+		l.Quantity = line.Quantity.Negate().String()
+		t := total.Negate()
+		total = &t
+		l.NetPriceTotal = total.String()
 	}
 	if tc := line.Taxes.Get(tax.CategoryVAT); tc != nil {
-		if tc.Percent != nil {
-			l.VATRate = tc.Percent.Rescale(cu).StringWithoutSymbol()
-			l.TaxValue = tc.Percent.Amount().Multiply(*line.Total).Rescale(cu).String() // TODO this is not correct
+		if tc.Ext.Get(favat.ExtKeyTaxCategory) == "5" {
+			if tc.Percent != nil {
+				l.OSSTaxRate = tc.Percent.Amount().MinimalString()
+			}
+		} else {
+			l.VATRate = vatRate(tc)
+		}
+		if tc.Percent != nil && total != nil {
+			l.TaxValue = tc.Percent.Of(*total).Rescale(cu).String()
 		}
 	}
 
 	return l
 }
 
-// NewOrderLines generates order lines for the KSeF invoice - TODO use in the future
+// pricesIncludeVAT checks if the line prices of the invoice include VAT, as
+// with retail sales, or if it uses a margin scheme, where VAT is not shown.
+func pricesIncludeVAT(invoice *bill.Invoice) bool {
+	if invoice.Tax == nil {
+		return false
+	}
+	return invoice.Tax.PricesInclude == tax.CategoryVAT || invoice.Tax.Ext.Get(favat.ExtKeyMarginScheme) != ""
+}
+
+// indexLines maps the invoice lines by their index, which is the number of
+// the KSeF line built from them.
+func indexLines(lines []*bill.Line) map[int]*bill.Line {
+	index := make(map[int]*bill.Line, len(lines))
+	for _, line := range lines {
+		index[line.Index] = line
+	}
+	return index
+}
+
+// setGrossPrices reports the unit prices and totals of lines with prices
+// including VAT in the gross price fields instead (art. 106e sec. 7 and 8).
+func (inv *Inv) setGrossPrices(invoice *bill.Invoice) {
+	if !pricesIncludeVAT(invoice) {
+		return
+	}
+	for _, l := range inv.Lines {
+		l.GrossUnitPrice, l.NetUnitPrice = l.NetUnitPrice, ""
+		l.GrossPriceTotal, l.NetPriceTotal = l.NetPriceTotal, ""
+	}
+}
+
+// setLineCompletionDates sets the dates of the supply of lines (P_6A) that
+// differ from the date common to the whole invoice, such as those of multiple
+// deliveries.
+func (inv *Inv) setLineCompletionDates(lines map[int]*bill.Line) {
+	for _, l := range inv.Lines {
+		line, ok := lines[l.LineNumber]
+		if !ok {
+			continue
+		}
+		if date := lineDate(line); date != nil && date.String() != inv.CompletionDate {
+			l.CompletionDate = date.String()
+		}
+	}
+}
+
+// setLineVAT reports the VAT amount of each line, rounded to the currency
+// subunits, on invoices that require it (art. 106e sec. 10). The VAT totals
+// of each rate are then the sums of the line amounts, unless there are taxed
+// charges or discounts outside the lines.
+func (inv *Inv) setLineVAT(invoice *bill.Invoice, lines map[int]*bill.Line) {
+	if invoice.Tax == nil || invoice.Tax.Ext.Get(ExtKeyLineVAT) != "1" {
+		return
+	}
+	cu := invoice.Currency.Def().Subunits
+	sums := make(map[cbc.Code]num.Amount)
+	for _, l := range inv.Lines {
+		line, ok := lines[l.LineNumber]
+		if !ok || line.Total == nil {
+			continue
+		}
+		tc := line.Taxes.Get(tax.CategoryVAT)
+		if tc == nil || tc.Percent == nil {
+			continue
+		}
+		total := *line.Total
+		if isBeforeCorrection(line) {
+			total = total.Negate()
+		}
+		vat := tc.Percent.Of(total)
+		if invoice.Tax.PricesInclude == tax.CategoryVAT {
+			vat = tc.Percent.From(total)
+		}
+		vat = vat.Rescale(cu)
+		l.VATAmount = vat.String()
+
+		// Lines before correction are subtracted from the totals
+		if isBeforeCorrection(line) {
+			vat = vat.Negate()
+		}
+		key := tc.Ext.Get(favat.ExtKeyTaxCategory)
+		if sum, ok := sums[key]; ok {
+			vat = sum.Add(vat)
+		}
+		sums[key] = vat
+	}
+
+	if hasTaxedChargesOrDiscounts(invoice) {
+		return
+	}
+	fields := map[cbc.Code]*string{
+		"1": &inv.StandardRateTax,
+		"2": &inv.ReducedRateTax,
+		"3": &inv.SuperReducedRateTax,
+		"4": &inv.TaxiRateTax,
+		"5": &inv.OSSTax,
+	}
+	diff := num.MakeAmount(0, cu)
+	for key, sum := range sums {
+		f, ok := fields[key]
+		if !ok || *f == "" {
+			continue
+		}
+		if total, err := num.AmountFromString(*f); err == nil {
+			diff = diff.Add(sum.Subtract(total))
+		}
+	}
+
+	// The line amounts are only reported in the totals when the rounding of
+	// the invoice accounts for them, so that they add up to the total due.
+	rounding := num.MakeAmount(0, cu)
+	if invoice.Totals.Rounding != nil {
+		rounding = *invoice.Totals.Rounding
+	}
+	if !diff.Equals(rounding) {
+		return
+	}
+	for key, sum := range sums {
+		if f, ok := fields[key]; ok && *f != "" {
+			*f = sum.String()
+		}
+	}
+}
+
+// lineVAT returns the sum of the VAT amounts reported on the lines, if any.
+// Lines before correction are subtracted.
+func (inv *Inv) lineVAT() (*num.Amount, error) {
+	var sum *num.Amount
+	for _, l := range inv.Lines {
+		if l.VATAmount == "" {
+			continue
+		}
+		amount, err := parseAmount(l.VATAmount)
+		if err != nil {
+			return nil, fmt.Errorf("parsing line %d VAT amount: %w", l.LineNumber, err)
+		}
+		if l.BeforeCorrectionMarker == 1 {
+			amount = amount.Negate()
+		}
+		if sum != nil {
+			amount = sum.Add(amount)
+		}
+		sum = &amount
+	}
+	return sum, nil
+}
+
+// removeIncludedVAT converts the prices of order lines that include VAT to
+// net prices, as order lines do not accept gross prices.
+func (o *Order) removeIncludedVAT(invoice *bill.Invoice, lines map[int]*bill.Line, cu uint32) {
+	if invoice.Tax == nil || invoice.Tax.PricesInclude != tax.CategoryVAT {
+		return
+	}
+	for _, l := range o.LineItems {
+		line, ok := lines[l.LineNumber]
+		if !ok {
+			continue
+		}
+		tc := line.Taxes.Get(tax.CategoryVAT)
+		if tc == nil || tc.Percent == nil {
+			continue
+		}
+		if price, err := num.AmountFromString(l.NetUnitPrice); err == nil {
+			l.NetUnitPrice = price.Subtract(tc.Percent.From(price)).String()
+		}
+		if total, err := num.AmountFromString(l.NetPriceTotal); err == nil {
+			vat := tc.Percent.From(total).Rescale(cu)
+			l.NetPriceTotal = total.Subtract(vat).String()
+			l.TaxValue = vat.String()
+		}
+	}
+}
+
+// NewOrderLines generates order lines for the KSeF invoice, with the VAT
+// amount of each line rounded to the currency subunits
 func NewOrderLines(lines []*bill.Line, cu uint32) []*OrderLine {
 	var orderLines []*OrderLine
 
@@ -174,6 +483,31 @@ func NewOrderLines(lines []*bill.Line, cu uint32) []*OrderLine {
 	return orderLines
 }
 
+// ToGOBL converts a KSEF order line to a GOBL Line.
+func (l *OrderLine) ToGOBL() (*bill.Line, error) {
+	line := &Line{
+		LineNumber:    l.LineNumber,
+		UniqueID:      l.UniqueID,
+		Name:          l.Name,
+		InternalCode:  l.InternalCode,
+		GTIN:          l.GTIN,
+		PKWiU:         l.PKWiU,
+		CN:            l.CN,
+		PKOB:          l.PKOB,
+		Measure:       l.Measure,
+		Quantity:      l.Quantity,
+		NetUnitPrice:  l.NetUnitPrice,
+		NetPriceTotal: l.NetPriceTotal,
+		VATRate:       l.VATRate,
+		OSSTaxRate:    l.OSSTaxRate,
+
+		SpecialGoodsCode:       l.SpecialGoodsCode,
+		Procedure:              l.Procedure,
+		BeforeCorrectionMarker: l.BeforeCorrectionMarker,
+	}
+	return line.ToGOBL()
+}
+
 // ToGOBL converts a KSEF Line to a GOBL Line.
 func (l *Line) ToGOBL() (*bill.Line, error) {
 	line := &bill.Line{
@@ -182,6 +516,50 @@ func (l *Line) ToGOBL() (*bill.Line, error) {
 		},
 	}
 
+	// Free text internal codes are kept in the item meta
+	if isCode(l.InternalCode) {
+		line.Item.Ref = cbc.Code(l.InternalCode)
+	} else if l.InternalCode != "" {
+		line.Item.Meta = cbc.Meta{MetaKeyIndex: l.InternalCode}
+	}
+
+	// The date of the supply of the line is its period end
+	if l.CompletionDate != "" {
+		date, err := parseDate(l.CompletionDate)
+		if err != nil {
+			return nil, err
+		}
+		line.Period = &cal.Period{Start: date, End: date}
+	}
+
+	// Line identifiers other than UUIDs are kept in the item meta, as lines
+	// have none
+	if id, err := uuid.Parse(l.UniqueID); err == nil {
+		line.UUID = id
+	} else if l.UniqueID != "" {
+		if line.Item.Meta == nil {
+			line.Item.Meta = make(cbc.Meta)
+		}
+		line.Item.Meta[MetaKeyLineID] = l.UniqueID
+	}
+
+	for _, id := range []struct {
+		key  cbc.Key
+		code string
+	}{
+		{org.IdentityKeyGTIN, l.GTIN},
+		{IdentityKeyPKWiU, l.PKWiU},
+		{IdentityKeyCN, l.CN},
+		{IdentityKeyPKOB, l.PKOB},
+	} {
+		if id.code != "" {
+			line.Item.Identities = append(line.Item.Identities, &org.Identity{
+				Key:  id.key,
+				Code: cbc.Code(id.code),
+			})
+		}
+	}
+
 	// Parse quantity
 	if l.Quantity != "" {
 		qty, err := parseAmount(l.Quantity)
@@ -191,13 +569,24 @@ func (l *Line) ToGOBL() (*bill.Line, error) {
 		line.Quantity = qty
 	}
 
-	// Parse unit price
-	if l.NetUnitPrice != "" {
-		price, err := parseAmount(l.NetUnitPrice)
+	// Parse unit price, which is gross on lines with prices including VAT
+	if p := l.unitPrice(); p != "" {
+		price, err := parseAmount(p)
 		if err != nil {
 			return nil, err
 		}
 		line.Item.Price = &price
+	} else if t := l.priceTotal(); t != "" {
+		// Lines with only a total have a price derived from it
+		total, err := parseAmount(t)
+		if err != nil {
+			return nil, err
+		}
+		if line.Quantity.IsZero() {
+			line.Quantity = num.MakeAmount(1, 0)
+		}
+		price := total.Divide(line.Quantity)
+		line.Item.Price = &price
 	}
 
 	// Parse unit of measure
@@ -205,12 +594,15 @@ func (l *Line) ToGOBL() (*bill.Line, error) {
 		line.Item.Unit = parseUnit(l.Measure)
 	}
 
-	// Parse discount
+	// Parse discount, reported per unit, into the discount of the line
 	if l.UnitDiscount != "" {
 		discount, err := parseAmount(l.UnitDiscount)
 		if err != nil {
 			return nil, err
 		}
+		if !line.Quantity.IsZero() {
+			discount = discount.Multiply(line.Quantity)
+		}
 		if !discount.IsZero() {
 			line.Discounts = []*bill.LineDiscount{
 				{
@@ -229,22 +621,50 @@ func (l *Line) ToGOBL() (*bill.Line, error) {
 	}
 
 	if rateStr != "" {
-		taxInfo := parseVATRate(rateStr)
-		taxCombo := &tax.Combo{
-			Category: tax.CategoryVAT,
-			Key:      taxInfo.Key,
-			Rate:     taxInfo.Rate,
-			Percent:  taxInfo.Percent,
-			Ext: tax.Extensions{
-				favat.ExtKeyTaxCategory: taxInfo.TaxCategory,
-			},
+		line.Taxes = tax.Set{parseVATRate(rateStr).taxCombo()}
+	}
+
+	// GTU codes classify the goods, while procedures apply to the supply
+	if l.SpecialGoodsCode != "" {
+		line.Item.Ext = line.Item.Ext.Set(ExtKeyGTU, cbc.Code(l.SpecialGoodsCode))
+	}
+	if l.Procedure != "" {
+		line.Ext = line.Ext.Set(ExtKeyProcedure, cbc.Code(l.Procedure))
+	}
+
+	// Lines before correction are negated, so that the totals only include
+	// the net correction
+	if l.BeforeCorrectionMarker == 1 {
+		line.Quantity = line.Quantity.Negate()
+		for _, d := range line.Discounts {
+			d.Amount = d.Amount.Negate()
 		}
-		line.Taxes = tax.Set{taxCombo}
+		line.Ext = line.Ext.Set(ExtKeyBeforeCorrection, "1")
 	}
 
 	return line, nil
 }
 
+// HasGrossPrices checks if the line reports prices including VAT.
+func (l *Line) HasGrossPrices() bool {
+	return l.NetUnitPrice == "" && l.NetPriceTotal == "" &&
+		(l.GrossUnitPrice != "" || l.GrossPriceTotal != "")
+}
+
+func (l *Line) unitPrice() string {
+	if l.NetUnitPrice != "" {
+		return l.NetUnitPrice
+	}
+	return l.GrossUnitPrice
+}
+
+func (l *Line) priceTotal() string {
+	if l.NetPriceTotal != "" {
+		return l.NetPriceTotal
+	}
+	return l.GrossPriceTotal
+}
+
 // parseAmount parses a string amount to num.Amount
 func parseAmount(s string) (num.Amount, error) {
 	amt, err := num.AmountFromString(s)
@@ -267,6 +687,19 @@ type TaxRateInfo struct {
 	TaxCategory cbc.Code
 }
 
+// taxCombo builds the GOBL VAT combo for the tax rate
+func (info *TaxRateInfo) taxCombo() *tax.Combo {
+	return &tax.Combo{
+		Category: tax.CategoryVAT,
+		Key:      info.Key,
+		Rate:     info.Rate,
+		Percent:  info.Percent,
+		Ext: tax.Extensions{
+			favat.ExtKeyTaxCategory: info.TaxCategory,
+		},
+	}
+}
+
 // parseVATRate converts KSEF VAT rate string to GOBL tax information.
 // KSEF uses various formats:
 // - "23", "8", "5" for standard rates
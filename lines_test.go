@@ -1,14 +1,20 @@
 package ksef_test
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	ksef "github.com/invopop/gobl.ksef"
+	"github.com/invopop/gobl.ksef/test"
 	"github.com/invopop/gobl/addons/pl/favat"
 	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
 	"github.com/invopop/gobl/num"
 	"github.com/invopop/gobl/org"
 	"github.com/invopop/gobl/tax"
+	"github.com/invopop/gobl/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -241,10 +247,7 @@ func TestNewOrderLines(t *testing.T) {
 		assert.Equal(t, "2", result[0].Quantity)
 		assert.Equal(t, "200.00", result[0].NetPriceTotal)
 		assert.Equal(t, "23", result[0].VATRate)
-		// Note: TaxValue calculation in current code uses Percent.Amount() which
-		// returns the percentage value directly (23), not the decimal (0.23),
-		// resulting in 200.00 * 23 = 4600.00 (there's a TODO in the code about this)
-		assert.Equal(t, "4600.00", result[0].TaxValue)
+		assert.Equal(t, "46.00", result[0].TaxValue)
 	})
 
 	t.Run("handles multiple order lines", func(t *testing.T) {
@@ -333,7 +336,7 @@ func TestLineToGOBL(t *testing.T) {
 
 		require.NoError(t, err)
 		assert.Len(t, line.Discounts, 1)
-		assert.Equal(t, "10.00", line.Discounts[0].Amount.String())
+		assert.Equal(t, "20.00", line.Discounts[0].Amount.String()) // 10.00 * 2 = 20.00
 	})
 
 	t.Run("handles exempt line with zero VAT", func(t *testing.T) {
@@ -464,3 +467,448 @@ func TestLineToGOBL(t *testing.T) {
 		assert.Equal(t, "5", line.Taxes[0].Percent.Amount().MinimalString())
 	})
 }
+
+func TestOrderLineToGOBL(t *testing.T) {
+	t.Run("converts KSEF order line to GOBL", func(t *testing.T) {
+		ksefLine := &ksef.OrderLine{
+			LineNumber:    1,
+			Name:          "Order Item",
+			Quantity:      "2",
+			NetUnitPrice:  "100.00",
+			Measure:       "HUR",
+			NetPriceTotal: "200.00",
+			TaxValue:      "46.00",
+			VATRate:       "23",
+		}
+
+		line, err := ksefLine.ToGOBL()
+
+		require.NoError(t, err)
+		assert.Equal(t, "Order Item", line.Item.Name)
+		assert.Equal(t, "2", line.Quantity.String())
+		assert.Equal(t, "100.00", line.Item.Price.String())
+		assert.Equal(t, org.Unit("HUR"), line.Item.Unit)
+		require.Len(t, line.Taxes, 1)
+		assert.Equal(t, "23", line.Taxes[0].Percent.Amount().MinimalString())
+	})
+}
+
+func TestBeforeCorrectionLines(t *testing.T) {
+	t.Run("reports lines before correction with original amounts", func(t *testing.T) {
+		price := num.MakeAmount(1000, 2)
+		total := num.MakeAmount(-10000, 2)
+		lines := []*bill.Line{
+			{
+				Index:    1,
+				Quantity: num.MakeAmount(-10, 0),
+				Item:     &org.Item{Name: "Service", Price: &price},
+				Total:    &total,
+				Taxes: tax.Set{
+					&tax.Combo{Category: tax.CategoryVAT, Percent: num.NewPercentage(23, 2)},
+				},
+				Ext: tax.Extensions{ksef.ExtKeyBeforeCorrection: "1"},
+			},
+		}
+
+		result := ksef.NewLines(lines)
+		require.Len(t, result, 1)
+		assert.Equal(t, 1, result[0].BeforeCorrectionMarker)
+		assert.Equal(t, "10", result[0].Quantity)
+		assert.Equal(t, "100.00", result[0].NetPriceTotal)
+
+		orderLines := ksef.NewOrderLines(lines, 2)
+		require.Len(t, orderLines, 1)
+		assert.Equal(t, 1, orderLines[0].BeforeCorrectionMarker)
+		assert.Equal(t, "10", orderLines[0].Quantity)
+		assert.Equal(t, "100.00", orderLines[0].NetPriceTotal)
+		assert.Equal(t, "23.00", orderLines[0].TaxValue)
+	})
+
+	t.Run("negates lines before correction when parsing", func(t *testing.T) {
+		ksefLine := &ksef.Line{
+			Name:                   "Service",
+			Quantity:               "10",
+			NetUnitPrice:           "10.00",
+			NetPriceTotal:          "100.00",
+			VATRate:                "23",
+			BeforeCorrectionMarker: 1,
+		}
+
+		line, err := ksefLine.ToGOBL()
+
+		require.NoError(t, err)
+		assert.Equal(t, "-10", line.Quantity.String())
+		assert.Equal(t, cbc.Code("1"), line.Ext.Get(ksef.ExtKeyBeforeCorrection))
+	})
+
+	t.Run("keeps the unit discount of lines before correction on a round trip", func(t *testing.T) {
+		ksefLine := &ksef.Line{
+			LineNumber:             1,
+			Name:                   "Service",
+			Quantity:               "10",
+			NetUnitPrice:           "10.00",
+			UnitDiscount:           "1.50",
+			NetPriceTotal:          "85.00",
+			VATRate:                "23",
+			BeforeCorrectionMarker: 1,
+		}
+
+		line, err := ksefLine.ToGOBL()
+		require.NoError(t, err)
+		require.Len(t, line.Discounts, 1)
+		assert.Equal(t, "-15.00", line.Discounts[0].Amount.String())
+
+		total := num.MakeAmount(-8500, 2)
+		line.Total = &total
+		result := ksef.NewLines([]*bill.Line{line})
+		require.Len(t, result, 1)
+		assert.Equal(t, "10", result[0].Quantity)
+		assert.Equal(t, "1.50", result[0].UnitDiscount)
+		assert.Equal(t, "85.00", result[0].NetPriceTotal)
+	})
+
+	t.Run("keeps the unit discount of lines after correction on a round trip", func(t *testing.T) {
+		ksefLine := &ksef.Line{
+			LineNumber:    1,
+			Name:          "Service",
+			Quantity:      "10",
+			NetUnitPrice:  "10.00",
+			UnitDiscount:  "1.50",
+			NetPriceTotal: "85.00",
+			VATRate:       "23",
+		}
+
+		line, err := ksefLine.ToGOBL()
+		require.NoError(t, err)
+		require.Len(t, line.Discounts, 1)
+		assert.Equal(t, "15.00", line.Discounts[0].Amount.String())
+
+		total := num.MakeAmount(8500, 2)
+		line.Total = &total
+		result := ksef.NewLines([]*bill.Line{line})
+		require.Len(t, result, 1)
+		assert.Equal(t, "1.50", result[0].UnitDiscount)
+	})
+}
+
+func TestLineCodes(t *testing.T) {
+	price := num.MakeAmount(10000, 2)
+	newLine := func() *bill.Line {
+		return &bill.Line{
+			Index:    1,
+			Quantity: num.MakeAmount(1, 0),
+			Item: &org.Item{
+				Name:  "Laptop",
+				Price: &price,
+				Ext:   tax.Extensions{ksef.ExtKeyGTU: "GTU_06"},
+			},
+			Total: &price,
+			Ext:   tax.Extensions{ksef.ExtKeyProcedure: "TT_D"},
+		}
+	}
+
+	t.Run("sets the codes of the line and its item", func(t *testing.T) {
+		result := ksef.NewLines([]*bill.Line{newLine()})
+		require.Len(t, result, 1)
+		assert.Equal(t, "GTU_06", result[0].SpecialGoodsCode)
+		assert.Equal(t, "TT_D", result[0].Procedure)
+
+		orderLines := ksef.NewOrderLines([]*bill.Line{newLine()}, 2)
+		require.Len(t, orderLines, 1)
+		assert.Equal(t, "GTU_06", orderLines[0].SpecialGoodsCode)
+		assert.Equal(t, "TT_D", orderLines[0].Procedure)
+	})
+
+	t.Run("prefers the codes of the line over the item", func(t *testing.T) {
+		line := newLine()
+		line.Ext = line.Ext.Set(ksef.ExtKeyGTU, "GTU_12")
+
+		result := ksef.NewLines([]*bill.Line{line})
+		assert.Equal(t, "GTU_12", result[0].SpecialGoodsCode)
+	})
+
+	t.Run("parses the codes", func(t *testing.T) {
+		ksefLine := &ksef.OrderLine{
+			LineNumber:       1,
+			Name:             "Laptop",
+			Quantity:         "1",
+			NetUnitPrice:     "100.00",
+			VATRate:          "23",
+			SpecialGoodsCode: "GTU_06",
+			Procedure:        "WSTO_EE",
+		}
+
+		line, err := ksefLine.ToGOBL()
+
+		require.NoError(t, err)
+		assert.Equal(t, cbc.Code("GTU_06"), line.Item.Ext.Get(ksef.ExtKeyGTU))
+		assert.Equal(t, cbc.Code("WSTO_EE"), line.Ext.Get(ksef.ExtKeyProcedure))
+	})
+}
+
+func TestLineIdentifiers(t *testing.T) {
+	price := num.MakeAmount(10000, 2)
+	line := &bill.Line{
+		Identify: uuid.Identify{UUID: uuid.MustParse("0192763a-9b12-7e44-b7c1-4d2e6f8a1c40")},
+		Index:    1,
+		Quantity: num.MakeAmount(1, 0),
+		Item: &org.Item{
+			Name:  "Laptop",
+			Ref:   "LAP-15",
+			Price: &price,
+			Identities: []*org.Identity{
+				{Key: org.IdentityKeyGTIN, Code: "05901234123457"},
+				{Key: ksef.IdentityKeyPKWiU, Code: "26.20.11.0"},
+				{Key: ksef.IdentityKeyCN, Code: "84713000"},
+				{Key: ksef.IdentityKeyPKOB, Code: "1274"},
+			},
+		},
+		Total: &price,
+	}
+
+	t.Run("sets the line and item identifiers", func(t *testing.T) {
+		result := ksef.NewLines([]*bill.Line{line})
+		require.Len(t, result, 1)
+		assert.Equal(t, "0192763a-9b12-7e44-b7c1-4d2e6f8a1c40", result[0].UniqueID)
+		assert.Equal(t, "LAP-15", result[0].InternalCode)
+		assert.Equal(t, "05901234123457", result[0].GTIN)
+		assert.Equal(t, "26.20.11.0", result[0].PKWiU)
+		assert.Equal(t, "84713000", result[0].CN)
+		assert.Equal(t, "1274", result[0].PKOB)
+
+		orderLines := ksef.NewOrderLines([]*bill.Line{line}, 2)
+		require.Len(t, orderLines, 1)
+		assert.Equal(t, "0192763a-9b12-7e44-b7c1-4d2e6f8a1c40", orderLines[0].UniqueID)
+		assert.Equal(t, "LAP-15", orderLines[0].InternalCode)
+		assert.Equal(t, "1274", orderLines[0].PKOB)
+	})
+
+	t.Run("parses the line and item identifiers", func(t *testing.T) {
+		ksefLine := &ksef.OrderLine{
+			LineNumber:   1,
+			UniqueID:     "0192763a-9b12-7e44-b7c1-4d2e6f8a1c40",
+			Name:         "Laptop",
+			InternalCode: "LAP-15",
+			GTIN:         "05901234123457",
+			CN:           "84713000",
+			Quantity:     "1",
+			NetUnitPrice: "100.00",
+		}
+
+		line, err := ksefLine.ToGOBL()
+
+		require.NoError(t, err)
+		assert.Equal(t, "0192763a-9b12-7e44-b7c1-4d2e6f8a1c40", line.UUID.String())
+		assert.Equal(t, cbc.Code("LAP-15"), line.Item.Ref)
+		require.Len(t, line.Item.Identities, 2)
+		assert.Equal(t, org.IdentityKeyGTIN, line.Item.Identities[0].Key)
+		assert.Equal(t, cbc.Code("05901234123457"), line.Item.Identities[0].Code)
+		assert.Equal(t, ksef.IdentityKeyCN, line.Item.Identities[1].Key)
+	})
+
+	t.Run("keeps line identifiers that are not UUIDs in the item meta", func(t *testing.T) {
+		ksefLine := &ksef.Line{LineNumber: 1, UniqueID: "LINE-1", Name: "Laptop", Quantity: "1", NetUnitPrice: "100.00"}
+
+		line, err := ksefLine.ToGOBL()
+
+		require.NoError(t, err)
+		assert.True(t, line.UUID.IsZero())
+		assert.Equal(t, "LINE-1", line.Item.Meta[ksef.MetaKeyLineID])
+
+		line.Total = line.Item.Price
+		result := ksef.NewLines([]*bill.Line{line})
+		assert.Equal(t, "LINE-1", result[0].UniqueID)
+	})
+
+	t.Run("keeps internal codes that are not valid codes in the item meta", func(t *testing.T) {
+		ksefLine := &ksef.Line{LineNumber: 1, InternalCode: "Laptop 15\" (srebrny)", Name: "Laptop", Quantity: "1", NetUnitPrice: "100.00"}
+
+		line, err := ksefLine.ToGOBL()
+
+		require.NoError(t, err)
+		assert.Empty(t, line.Item.Ref)
+		assert.Equal(t, "Laptop 15\" (srebrny)", line.Item.Meta[ksef.MetaKeyIndex])
+
+		line.Total = line.Item.Price
+		result := ksef.NewLines([]*bill.Line{line})
+		assert.Equal(t, "Laptop 15\" (srebrny)", result[0].InternalCode)
+	})
+
+	t.Run("truncates the identifiers to their maximum lengths", func(t *testing.T) {
+		long := *line
+		long.Item = &org.Item{
+			Name:  "Laptop",
+			Ref:   cbc.Code(strings.Repeat("A", 60)),
+			Price: &price,
+			Identities: []*org.Identity{
+				{Key: org.IdentityKeyGTIN, Code: cbc.Code(strings.Repeat("1", 25))},
+			},
+		}
+
+		result := ksef.NewLines([]*bill.Line{&long})
+		require.Len(t, result, 1)
+		assert.Equal(t, strings.Repeat("A", 50), result[0].InternalCode)
+		assert.Equal(t, strings.Repeat("1", 20), result[0].GTIN)
+	})
+}
+
+func TestGrossPrices(t *testing.T) {
+	t.Run("reports gross prices when prices include VAT", func(t *testing.T) {
+		env, err := test.LoadTestEnvelope("invoice-gross-prices.json")
+		require.NoError(t, err)
+
+		inv := ksef.NewFavatInv(env.Extract().(*bill.Invoice))
+
+		require.Len(t, inv.Lines, 2)
+		assert.Equal(t, "246.00", inv.Lines[0].GrossUnitPrice)
+		assert.Equal(t, "738.00", inv.Lines[0].GrossPriceTotal)
+		assert.Empty(t, inv.Lines[0].NetUnitPrice)
+		assert.Empty(t, inv.Lines[0].NetPriceTotal)
+		assert.Equal(t, "600.00", inv.StandardRateNetSale)
+	})
+
+	t.Run("reports gross prices of margin scheme invoices", func(t *testing.T) {
+		env, err := test.LoadTestEnvelope("invoice-standard.json")
+		require.NoError(t, err)
+		goblInv := env.Extract().(*bill.Invoice)
+		goblInv.Tax.Ext[favat.ExtKeyMarginScheme] = "3.1"
+
+		inv := ksef.NewFavatInv(goblInv)
+
+		assert.Equal(t, "100.00", inv.Lines[0].GrossUnitPrice)
+		assert.Equal(t, "1000.00", inv.Lines[0].GrossPriceTotal)
+	})
+
+	t.Run("reports net prices on order lines", func(t *testing.T) {
+		env, err := test.LoadTestEnvelope("invoice-gross-prices.json")
+		require.NoError(t, err)
+		goblInv := env.Extract().(*bill.Invoice)
+		goblInv.SetTags(tax.TagPartial)
+
+		order := ksef.NewOrder(goblInv)
+
+		require.Len(t, order.LineItems, 2)
+		assert.Equal(t, "200.00", order.LineItems[0].NetUnitPrice)
+		assert.Equal(t, "600.00", order.LineItems[0].NetPriceTotal)
+		assert.Equal(t, "138.00", order.LineItems[0].TaxValue)
+		assert.Equal(t, "1278.00", order.OrderAmount)
+	})
+
+	t.Run("parses lines with only a gross total", func(t *testing.T) {
+		ksefLine := &ksef.Line{
+			LineNumber:      1,
+			Name:            "Used car",
+			GrossPriceTotal: "45000.00",
+		}
+
+		line, err := ksefLine.ToGOBL()
+
+		require.NoError(t, err)
+		assert.True(t, ksefLine.HasGrossPrices())
+		assert.Equal(t, "1", line.Quantity.String())
+		assert.Equal(t, "45000.00", line.Item.Price.String())
+	})
+
+	t.Run("sets prices including VAT when parsing", func(t *testing.T) {
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", "invoice-gross-prices.xml"))
+		require.NoError(t, err)
+
+		env, err := ksef.ParseKSeF(data)
+		require.NoError(t, err)
+		inv := env.Extract().(*bill.Invoice)
+
+		assert.Equal(t, tax.CategoryVAT, inv.Tax.PricesInclude)
+		assert.Equal(t, "246.00", inv.Lines[0].Item.Price.String())
+		assert.Equal(t, "1278.00", inv.Totals.Payable.String())
+		assert.Equal(t, "163.71", inv.Totals.Tax.String())
+	})
+}
+
+func TestLineVAT(t *testing.T) {
+	t.Run("reports the VAT amount of each line", func(t *testing.T) {
+		env, err := test.LoadTestEnvelope("invoice-line-vat.json")
+		require.NoError(t, err)
+
+		inv := ksef.NewFavatInv(env.Extract().(*bill.Invoice))
+
+		require.Len(t, inv.Lines, 3)
+		for _, l := range inv.Lines {
+			assert.Equal(t, "0.26", l.VATAmount)
+		}
+		assert.Equal(t, "0.78", inv.StandardRateTax)
+		assert.Equal(t, "4.23", inv.TotalAmountDue)
+	})
+
+	t.Run("does not report line VAT amounts by default", func(t *testing.T) {
+		env, err := test.LoadTestEnvelope("invoice-line-vat.json")
+		require.NoError(t, err)
+		goblInv := env.Extract().(*bill.Invoice)
+		delete(goblInv.Tax.Ext, ksef.ExtKeyLineVAT)
+
+		inv := ksef.NewFavatInv(goblInv)
+
+		assert.Empty(t, inv.Lines[0].VATAmount)
+		assert.Equal(t, "0.79", inv.StandardRateTax)
+	})
+
+	t.Run("uses the line VAT amounts when parsing", func(t *testing.T) {
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", "invoice-line-vat.xml"))
+		require.NoError(t, err)
+
+		env, err := ksef.ParseKSeF(data)
+		require.NoError(t, err)
+		inv := env.Extract().(*bill.Invoice)
+
+		assert.Equal(t, cbc.Code("1"), inv.Tax.Ext.Get(ksef.ExtKeyLineVAT))
+		require.NotNil(t, inv.Totals.Rounding)
+		assert.Equal(t, "-0.01", inv.Totals.Rounding.String())
+		assert.Equal(t, "4.23", inv.Totals.Payable.String())
+	})
+
+	t.Run("keeps the VAT totals when the rounding does not include the line amounts", func(t *testing.T) {
+		env, err := test.LoadTestEnvelope("invoice-line-vat.json")
+		require.NoError(t, err)
+		goblInv := env.Extract().(*bill.Invoice)
+		goblInv.Totals.Rounding = nil
+		goblInv.Totals.Payable = goblInv.Totals.TotalWithTax
+
+		inv := ksef.NewFavatInv(goblInv)
+
+		assert.Equal(t, "0.26", inv.Lines[0].VATAmount)
+		assert.Equal(t, "0.79", inv.StandardRateTax)
+		assert.Equal(t, "4.24", inv.TotalAmountDue)
+	})
+
+	t.Run("subtracts the line VAT amounts before correction when parsing", func(t *testing.T) {
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", "invoice-line-vat.xml"))
+		require.NoError(t, err)
+		xml := strings.NewReplacer(
+			"<P_13_1>3.45</P_13_1>", "<P_13_1>2.30</P_13_1>",
+			"<P_14_1>0.78</P_14_1>", "<P_14_1>0.52</P_14_1>",
+			"<P_15>4.23</P_15>", "<P_15>2.82</P_15>",
+			"<P_12>23</P_12>\n    </FaWiersz>\n  </Fa>", `<P_12>23</P_12>
+    </FaWiersz>
+    <FaWiersz>
+      <NrWierszaFa>4</NrWierszaFa>
+      <P_7>Sharpener</P_7>
+      <P_8A>EA</P_8A>
+      <P_8B>1</P_8B>
+      <P_9A>1.15</P_9A>
+      <P_11>1.15</P_11>
+      <P_11Vat>0.26</P_11Vat>
+      <P_12>23</P_12>
+      <StanPrzed>1</StanPrzed>
+    </FaWiersz>
+  </Fa>`,
+		).Replace(string(data))
+
+		env, err := ksef.ParseKSeF([]byte(xml))
+		require.NoError(t, err)
+		inv := env.Extract().(*bill.Invoice)
+
+		require.Len(t, inv.Lines, 4)
+		require.NotNil(t, inv.Totals.Rounding)
+		assert.Equal(t, "-0.01", inv.Totals.Rounding.String())
+		assert.Equal(t, "2.82", inv.Totals.Payable.String())
+	})
+}
@@ -0,0 +1,286 @@
+package ksef
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/currency"
+	"github.com/invopop/gobl/num"
+)
+
+// ExchangeRateSourceNBP is the source of the average exchange rates published
+// by the National Bank of Poland (NBP) in its table A.
+const ExchangeRateSourceNBP cbc.Key = "nbp"
+
+// nbpRateExp is the minimum number of decimals of the NBP average rates
+const nbpRateExp = 4
+
+// ErrNBPRateNotFound indicates that the NBP table does not include the rate
+// for the currency on the required date.
+var ErrNBPRateNotFound = errors.New("rate not found in NBP table")
+
+// NBPProvider provides the average exchange rates of the NBP table A read from
+// a local file, following the rule of the VAT act that applies the rate of the
+// last business day before the tax point (art. 31a).
+type NBPProvider struct {
+	rates map[cal.Date]map[currency.Code]num.Amount
+}
+
+// nbpTable defines the JSON and XML structure of the NBP API tables
+type nbpTable struct {
+	Table         string     `json:"table" xml:"Table"`
+	No            string     `json:"no" xml:"No"`
+	EffectiveDate string     `json:"effectiveDate" xml:"EffectiveDate"`
+	Rates         []*nbpRate `json:"rates" xml:"Rates>Rate"`
+}
+
+type nbpRate struct {
+	Code string      `json:"code" xml:"Code"`
+	Mid  json.Number `json:"mid" xml:"Mid"`
+}
+
+type nbpTables struct {
+	Tables []*nbpTable `xml:"ExchangeRatesTable"`
+}
+
+// LoadNBPProvider reads the NBP table A file with the given name.
+func LoadNBPProvider(filename string) (*NBPProvider, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("reading NBP table: %w", err)
+	}
+	return NewNBPProvider(data)
+}
+
+// NewNBPProvider parses the NBP table A data, either in the JSON or XML formats
+// of the NBP API, or in the CSV format of the NBP yearly archives.
+func NewNBPProvider(data []byte) (*NBPProvider, error) {
+	p := &NBPProvider{
+		rates: make(map[cal.Date]map[currency.Code]num.Amount),
+	}
+
+	var err error
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	switch {
+	case len(data) == 0:
+		return nil, errors.New("empty NBP table")
+	case data[0] == '[' || data[0] == '{':
+		err = p.parseJSON(data)
+	case data[0] == '<':
+		err = p.parseXML(data)
+	default:
+		err = p.parseCSV(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing NBP table: %w", err)
+	}
+
+	return p, nil
+}
+
+// ExchangeRate returns the NBP average rate from the currency to PLN of the
+// last business day before the date.
+func (p *NBPProvider) ExchangeRate(cur currency.Code, date cal.Date) (*currency.ExchangeRate, error) {
+	day := previousBusinessDay(date)
+	amount, ok := p.rates[day][cur]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s on %s", ErrNBPRateNotFound, cur, day)
+	}
+	return &currency.ExchangeRate{
+		From:   cur,
+		To:     currency.PLN,
+		At:     cal.NewDateTime(day.Year, day.Month, day.Day, 0, 0, 0),
+		Source: ExchangeRateSourceNBP,
+		Amount: amount,
+	}, nil
+}
+
+func (p *NBPProvider) parseJSON(data []byte) error {
+	var tables []*nbpTable
+	if data[0] == '{' {
+		data = append(append([]byte("["), data...), ']')
+	}
+	if err := json.Unmarshal(data, &tables); err != nil {
+		return err
+	}
+	return p.addTables(tables)
+}
+
+func (p *NBPProvider) parseXML(data []byte) error {
+	// The API returns a single table or an array of tables
+	var doc nbpTables
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if len(doc.Tables) == 0 {
+		var table nbpTable
+		if err := xml.Unmarshal(data, &table); err != nil {
+			return err
+		}
+		doc.Tables = []*nbpTable{&table}
+	}
+	return p.addTables(doc.Tables)
+}
+
+func (p *NBPProvider) addTables(tables []*nbpTable) error {
+	for _, t := range tables {
+		date, err := parseDate(t.EffectiveDate)
+		if err != nil {
+			return fmt.Errorf("table %s: %w", t.No, err)
+		}
+		for _, r := range t.Rates {
+			amount, err := num.AmountFromString(r.Mid.String())
+			if err != nil {
+				return fmt.Errorf("table %s, %s rate: %w", t.No, r.Code, err)
+			}
+			p.addRate(date, currency.Code(r.Code), amount, 0)
+		}
+	}
+	return nil
+}
+
+// parseCSV parses the archive format, with a header row of currencies
+// prefixed by their units (e.g. "1EUR", "100HUF"), and a row per table
+// starting with the date in the YYYYMMDD format, using decimal commas.
+func (p *NBPProvider) parseCSV(data []byte) error {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = ';'
+	r.FieldsPerRecord = -1
+
+	records, err := r.ReadAll()
+	if err != nil {
+		return err
+	}
+
+	var codes []currency.Code
+	var shifts []uint32
+	for _, rec := range records {
+		if len(rec) == 0 {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(rec[0]), "data") {
+			codes, shifts = parseCSVHeader(rec)
+			continue
+		}
+		t, err := time.Parse("20060102", strings.TrimSpace(rec[0]))
+		if err != nil {
+			// Rows with descriptions or table numbers
+			continue
+		}
+		date := cal.DateOf(t)
+		for i, value := range rec[1:] {
+			if i >= len(codes) || codes[i] == "" {
+				break
+			}
+			value = strings.ReplaceAll(strings.TrimSpace(value), ",", ".")
+			if value == "" {
+				continue
+			}
+			amount, err := num.AmountFromString(value)
+			if err != nil {
+				return fmt.Errorf("%s rate on %s: %w", codes[i], date, err)
+			}
+			p.addRate(date, codes[i], amount, shifts[i])
+		}
+	}
+
+	if len(codes) == 0 {
+		return errors.New("missing header row")
+	}
+	return nil
+}
+
+// parseCSVHeader returns the currency codes of the rate columns, and the
+// number of decimals to shift their rates, as they refer to units that are
+// powers of ten (e.g. 100 HUF).
+func parseCSVHeader(rec []string) ([]currency.Code, []uint32) {
+	var codes []currency.Code
+	var shifts []uint32
+	for _, col := range rec[1:] {
+		col = strings.TrimSpace(col)
+		unit := strings.TrimRight(col, "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+		if len(col)-len(unit) != 3 || !strings.HasPrefix(unit, "1") || strings.Trim(unit[1:], "0") != "" {
+			// Columns after the rates, such as the table number
+			codes = append(codes, "")
+			shifts = append(shifts, 0)
+			continue
+		}
+		codes = append(codes, currency.Code(col[len(unit):]))
+		shifts = append(shifts, uint32(len(unit)-1))
+	}
+	return codes, shifts
+}
+
+// addRate adds the rate of one unit of the currency, shifting the decimals
+// of rates given for larger units.
+func (p *NBPProvider) addRate(date cal.Date, cur currency.Code, amount num.Amount, shift uint32) {
+	amount = num.MakeAmount(amount.Value(), amount.Exp()+shift).RescaleUp(nbpRateExp)
+	if p.rates[date] == nil {
+		p.rates[date] = make(map[currency.Code]num.Amount)
+	}
+	p.rates[date][cur] = amount
+}
+
+// previousBusinessDay returns the last business day before the date,
+// skipping weekends and Polish public holidays.
+func previousBusinessDay(date cal.Date) cal.Date {
+	d := date.Add(0, 0, -1)
+	for isNonBusinessDay(d) {
+		d = d.Add(0, 0, -1)
+	}
+	return d
+}
+
+func isNonBusinessDay(d cal.Date) bool {
+	wd := d.Time().Weekday()
+	if wd == time.Saturday || wd == time.Sunday {
+		return true
+	}
+	return isPolishHoliday(d)
+}
+
+// isPolishHoliday checks the public holidays in Poland on weekdays. Christmas
+// Eve is a public holiday since 2025.
+func isPolishHoliday(d cal.Date) bool {
+	switch {
+	case d.Month == 1 && (d.Day == 1 || d.Day == 6),
+		d.Month == 5 && (d.Day == 1 || d.Day == 3),
+		d.Month == 8 && d.Day == 15,
+		d.Month == 11 && (d.Day == 1 || d.Day == 11),
+		d.Month == 12 && (d.Day == 25 || d.Day == 26),
+		d.Month == 12 && d.Day == 24 && d.Year >= 2025:
+		return true
+	}
+	easter := easterSunday(d.Year)
+	// Easter Monday and Corpus Christi
+	return d == easter.Add(0, 0, 1) || d == easter.Add(0, 0, 60)
+}
+
+// easterSunday calculates the date of Easter Sunday in the Gregorian
+// calendar, using the anonymous Gregorian algorithm.
+func easterSunday(year int) cal.Date {
+	a := year % 19
+	b := year / 100
+	c := year % 100
+	d := b / 4
+	e := b % 4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i := c / 4
+	k := c % 4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+	return cal.MakeDate(year, time.Month(month), day)
+}
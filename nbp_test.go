@@ -0,0 +1,108 @@
+package ksef_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	ksef "github.com/invopop/gobl.ksef"
+	"github.com/invopop/gobl.ksef/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/currency"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNBPProvider(t *testing.T) {
+	for _, name := range []string{"table-a.json", "table-a.xml", "table-a.csv"} {
+		t.Run("should load "+name, func(t *testing.T) {
+			p, err := ksef.LoadNBPProvider(filepath.Join(test.GetDataPath(), "nbp", name))
+			require.NoError(t, err)
+
+			rate, err := p.ExchangeRate(currency.EUR, cal.MakeDate(2026, 1, 20))
+			require.NoError(t, err)
+			assert.Equal(t, currency.EUR, rate.From)
+			assert.Equal(t, currency.PLN, rate.To)
+			assert.Equal(t, ksef.ExchangeRateSourceNBP, rate.Source)
+			assert.Equal(t, "4.2307", rate.Amount.String())
+			require.NotNil(t, rate.At)
+			assert.Equal(t, "2026-01-19", rate.At.Date().String())
+
+			rate, err = p.ExchangeRate(currency.HUF, cal.MakeDate(2026, 1, 20))
+			require.NoError(t, err)
+			assert.Equal(t, "0.011302", rate.Amount.String())
+		})
+	}
+
+	p, err := ksef.LoadNBPProvider(filepath.Join(test.GetDataPath(), "nbp", "table-a.json"))
+	require.NoError(t, err)
+
+	t.Run("should use the last business day before weekends", func(t *testing.T) {
+		rate, err := p.ExchangeRate(currency.EUR, cal.MakeDate(2026, 1, 12))
+		require.NoError(t, err)
+		assert.Equal(t, "2026-01-09", rate.At.Date().String())
+	})
+
+	t.Run("should skip public holidays", func(t *testing.T) {
+		// 6 January is Epiphany
+		rate, err := p.ExchangeRate(currency.EUR, cal.MakeDate(2026, 1, 7))
+		require.NoError(t, err)
+		assert.Equal(t, "2026-01-05", rate.At.Date().String())
+		assert.Equal(t, "4.2198", rate.Amount.String())
+	})
+
+	t.Run("should skip Easter Monday", func(t *testing.T) {
+		rate, err := p.ExchangeRate(currency.USD, cal.MakeDate(2026, 4, 7))
+		require.NoError(t, err)
+		assert.Equal(t, "2026-04-03", rate.At.Date().String())
+		assert.Equal(t, "3.7021", rate.Amount.String())
+	})
+
+	t.Run("should fail when the table is missing", func(t *testing.T) {
+		_, err := p.ExchangeRate(currency.EUR, cal.MakeDate(2026, 2, 10))
+		assert.ErrorIs(t, err, ksef.ErrNBPRateNotFound)
+	})
+
+	t.Run("should fail with invalid data", func(t *testing.T) {
+		_, err := ksef.NewNBPProvider([]byte("not a table"))
+		assert.Error(t, err)
+	})
+}
+
+func TestBuildFavatWithExchangeRateProvider(t *testing.T) {
+	p, err := ksef.LoadNBPProvider(filepath.Join(test.GetDataPath(), "nbp", "table-a.json"))
+	require.NoError(t, err)
+
+	t.Run("should add the missing rates at the invoice and line dates", func(t *testing.T) {
+		env, err := test.LoadTestEnvelope("invoice-foreign-currency.json")
+		require.NoError(t, err)
+		inv := env.Extract().(*bill.Invoice)
+		inv.ExchangeRates = nil
+
+		doc, err := ksef.BuildFavat(env, ksef.WithExchangeRateProvider(p))
+		require.NoError(t, err)
+
+		require.Len(t, inv.ExchangeRates, 3)
+		assert.Nil(t, inv.ExchangeRates[0].At)
+		assert.Equal(t, "4.2307", inv.ExchangeRates[0].Amount.String())
+		assert.Equal(t, ksef.ExchangeRateSourceNBP, inv.ExchangeRates[0].Source)
+
+		require.Len(t, doc.Inv.Lines, 2)
+		assert.Equal(t, "4.2150", doc.Inv.Lines[0].CurrencyRate)
+		assert.Equal(t, "4.2231", doc.Inv.Lines[1].CurrencyRate)
+	})
+
+	t.Run("should keep the rates of the invoice", func(t *testing.T) {
+		env, err := test.LoadTestEnvelope("invoice-foreign-currency.json")
+		require.NoError(t, err)
+		inv := env.Extract().(*bill.Invoice)
+
+		doc, err := ksef.BuildFavat(env, ksef.WithExchangeRateProvider(p))
+		require.NoError(t, err)
+
+		// Only the rate of the second line is missing
+		require.Len(t, inv.ExchangeRates, 3)
+		assert.Equal(t, "4.2310", doc.Inv.Lines[0].CurrencyRate)
+		assert.Equal(t, "4.2231", doc.Inv.Lines[1].CurrencyRate)
+	})
+}
@@ -1,6 +1,9 @@
 package ksef
 
 import (
+	"fmt"
+	"strconv"
+
 	"github.com/invopop/gobl/addons/pl/favat"
 	"github.com/invopop/gobl/bill"
 	"github.com/invopop/gobl/cbc"
@@ -9,6 +12,36 @@ import (
 	"github.com/invopop/gobl/tax"
 )
 
+// Identities of the parties reported in their KSeF data
+const (
+	// IdentityTypeEORI identifies the EORI number of parties that import
+	// or export goods (NrEORI).
+	IdentityTypeEORI cbc.Code = "EORI"
+	// IdentityKeyCustomerNumber identifies the number assigned by the
+	// supplier to the customer (NrKlienta).
+	IdentityKeyCustomerNumber cbc.Key = "customer-number"
+
+	// IdentityKeyInternalID identifies an internal unit of a party (IDWew),
+	// such as a VAT group member, by its NIP and unit number.
+	IdentityKeyInternalID cbc.Key = "internal-id"
+
+	// MetaKeyShare holds the percentage share of an additional buyer (Udzial).
+	MetaKeyShare cbc.Key = "share"
+	// MetaKeyCustomerNumber holds a customer number (NrKlienta) that is not a
+	// valid code, and so cannot be kept as an identity.
+	MetaKeyCustomerNumber cbc.Key = "customer-number"
+)
+
+// Roles of the third parties (Podmiot3) taken from the invoice parties
+const (
+	thirdPartyRoleFactor    = "1"
+	thirdPartyRoleRecipient = "2"
+	thirdPartyRoleOriginal  = "3"
+	thirdPartyRoleBuyer     = "4"
+	thirdPartyRoleIssuer    = "5"
+	thirdPartyRolePayer     = "6"
+)
+
 // Address defines the XML structure for KSeF addresses
 type Address struct {
 	CountryCode string `xml:"KodKraju"`
@@ -19,14 +52,14 @@ type Address struct {
 
 // Seller defines the XML structure for KSeF seller
 type Seller struct {
-	VATPrefix             string          `xml:"PrefiksPodatnika,omitempty"`
-	NIP                   string          `xml:"DaneIdentyfikacyjne>NIP"`
-	Name                  string          `xml:"DaneIdentyfikacyjne>Nazwa"`
-	EORI                  string          `xml:"NrEORI,omitempty"`
-	Address               *Address        `xml:"Adres"`
-	CorrespondenceAddress *Address        `xml:"AdresKoresp,omitempty"`
-	Contact               *ContactDetails `xml:"DaneKontaktowe,omitempty"`
-	TaxpayerStatus        int             `xml:"StatusInfoPodatnika,omitempty"` // 1=liquidation, 2=restructuring, 3=bankruptcy, 4=inheritance
+	VATPrefix             string            `xml:"PrefiksPodatnika,omitempty"`
+	EORI                  string            `xml:"NrEORI,omitempty"`
+	NIP                   string            `xml:"DaneIdentyfikacyjne>NIP"`
+	Name                  string            `xml:"DaneIdentyfikacyjne>Nazwa"`
+	Address               *Address          `xml:"Adres"`
+	CorrespondenceAddress *Address          `xml:"AdresKoresp,omitempty"`
+	Contacts              []*ContactDetails `xml:"DaneKontaktowe,omitempty"`      // up to 3
+	TaxpayerStatus        int               `xml:"StatusInfoPodatnika,omitempty"` // 1=liquidation, 2=restructuring, 3=bankruptcy, 4=inheritance
 }
 
 // ContactDetails defines the XML structure for KSeF contact
@@ -35,8 +68,19 @@ type ContactDetails struct {
 	Phone string `xml:"Telefon,omitempty"`
 }
 
+// maxContacts is the maximum number of contact details of a party
+const maxContacts = 3
+
+// maxCustomerNumber is the maximum length of a customer number
+const maxCustomerNumber = 256
+
+// maxThirdParties is the maximum number of third parties (Podmiot3)
+const maxThirdParties = 100
+
 // Buyer defines the XML structure for KSeF buyer
 type Buyer struct {
+	EORI string `xml:"NrEORI,omitempty"`
+
 	NIP string `xml:"DaneIdentyfikacyjne>NIP,omitempty"`
 	// or
 	UECode      string `xml:"DaneIdentyfikacyjne>KodUE,omitempty"`   // Country code when in European Union
@@ -47,13 +91,12 @@ type Buyer struct {
 	// or
 	NoID int `xml:"DaneIdentyfikacyjne>BrakID,omitempty"`
 
-	Name                  string          `xml:"DaneIdentyfikacyjne>Nazwa,omitempty"`
-	BuyerID               string          `xml:"IDNabywcy,omitempty"`
-	EORI                  string          `xml:"NrEORI,omitempty"`
-	Address               *Address        `xml:"Adres,omitempty"`
-	CorrespondenceAddress *Address        `xml:"AdresKoresp,omitempty"`
-	Contact               *ContactDetails `xml:"DaneKontaktowe,omitempty"`
-	CustomerNumber        string          `xml:"NrKlienta,omitempty"`
+	Name                  string            `xml:"DaneIdentyfikacyjne>Nazwa,omitempty"`
+	Address               *Address          `xml:"Adres,omitempty"`
+	CorrespondenceAddress *Address          `xml:"AdresKoresp,omitempty"`
+	Contacts              []*ContactDetails `xml:"DaneKontaktowe,omitempty"` // up to 3
+	CustomerNumber        string            `xml:"NrKlienta,omitempty"`
+	BuyerID               string            `xml:"IDNabywcy,omitempty"`
 
 	JST string `xml:"JST"` // JST (Jednostka Samorządu Terytorialnego = local government unit) 1 = Yes, 2 = No
 	GV  string `xml:"GV"`  // GV (Group VAT) 1 = Yes, 2 = No
@@ -61,24 +104,24 @@ type Buyer struct {
 
 // ThirdParty defines the XML structure for KSeF third party (Podmiot3)
 type ThirdParty struct {
-	BuyerID               string          `xml:"IDNabywcy,omitempty"`
-	EORI                  string          `xml:"NrEORI,omitempty"`
-	NIP                   string          `xml:"DaneIdentyfikacyjne>NIP,omitempty"`
-	InternalID            string          `xml:"DaneIdentyfikacyjne>IDWew,omitempty"`
-	UECode                string          `xml:"DaneIdentyfikacyjne>KodUE,omitempty"`
-	UEVatNumber           string          `xml:"DaneIdentyfikacyjne>NrVatUE,omitempty"`
-	CountryCode           string          `xml:"DaneIdentyfikacyjne>KodKraju,omitempty"`
-	IDNumber              string          `xml:"DaneIdentyfikacyjne>NrID,omitempty"`
-	NoID                  int             `xml:"DaneIdentyfikacyjne>BrakID,omitempty"`
-	Name                  string          `xml:"DaneIdentyfikacyjne>Nazwa,omitempty"`
-	Address               *Address        `xml:"Adres,omitempty"`
-	CorrespondenceAddress *Address        `xml:"AdresKoresp,omitempty"`
-	Contact               *ContactDetails `xml:"DaneKontaktowe,omitempty"`
-	Role                  string          `xml:"Rola,omitempty"`     // TRolaPodmiotu3: 1-11
-	OtherRole             int             `xml:"RolaInna,omitempty"` // 1 for other role
-	OtherRoleDescription  string          `xml:"OpisRoli,omitempty"` // description when OtherRole=1
-	Share                 string          `xml:"Udzial,omitempty"`   // percentage share
-	CustomerNumber        string          `xml:"NrKlienta,omitempty"`
+	BuyerID               string            `xml:"IDNabywcy,omitempty"`
+	EORI                  string            `xml:"NrEORI,omitempty"`
+	NIP                   string            `xml:"DaneIdentyfikacyjne>NIP,omitempty"`
+	InternalID            string            `xml:"DaneIdentyfikacyjne>IDWew,omitempty"`
+	UECode                string            `xml:"DaneIdentyfikacyjne>KodUE,omitempty"`
+	UEVatNumber           string            `xml:"DaneIdentyfikacyjne>NrVatUE,omitempty"`
+	CountryCode           string            `xml:"DaneIdentyfikacyjne>KodKraju,omitempty"`
+	IDNumber              string            `xml:"DaneIdentyfikacyjne>NrID,omitempty"`
+	NoID                  int               `xml:"DaneIdentyfikacyjne>BrakID,omitempty"`
+	Name                  string            `xml:"DaneIdentyfikacyjne>Nazwa,omitempty"`
+	Address               *Address          `xml:"Adres,omitempty"`
+	CorrespondenceAddress *Address          `xml:"AdresKoresp,omitempty"`
+	Contacts              []*ContactDetails `xml:"DaneKontaktowe,omitempty"` // up to 3
+	Role                  string            `xml:"Rola,omitempty"`           // TRolaPodmiotu3: 1-11
+	OtherRole             int               `xml:"RolaInna,omitempty"`       // 1 for other role
+	OtherRoleDescription  string            `xml:"OpisRoli,omitempty"`       // description when OtherRole=1
+	Share                 string            `xml:"Udzial,omitempty"`         // percentage share
+	CustomerNumber        string            `xml:"NrKlienta,omitempty"`
 }
 
 // AuthorizedEntity defines the XML structure for KSeF authorized entity (PodmiotUpowazniony)
@@ -93,42 +136,19 @@ type AuthorizedEntity struct {
 	Role                  int      `xml:"RolaPU"` // 1=enforcement authority, 2=court bailiff, 3=tax representative
 }
 
-// newAddress gets the address data from GOBL address
-func newAddress(address *org.Address) *Address {
-	addressLine1 := addressLine1(address)
-	var addressLine2 string
-	if len(addressLine1) > 512 {
-		addressLine2 = addressLine1[512:]
-		addressLine1 = addressLine1[:512]
-	}
-	adres := &Address{
-		CountryCode: string(address.Country),
-		AddressL1:   addressLine1,
-		AddressL2:   addressLine2,
-	}
-
-	return adres
-}
-
 // NewFavatSeller converts a GOBL Party into a KSeF seller
 func NewFavatSeller(supplier *org.Party) *Seller {
 	seller := &Seller{
 		VATPrefix: supplier.TaxID.Country.String(),
-		Address:   newAddress(supplier.Addresses[0]),
+		EORI:      partyIdentityType(supplier, IdentityTypeEORI),
 		NIP:       string(supplier.TaxID.Code),
 		Name:      supplier.Name,
 	}
-	if len(supplier.Telephones) > 0 {
-		seller.Contact = &ContactDetails{
-			Phone: supplier.Telephones[0].Number,
-		}
-	}
-	if len(supplier.Emails) > 0 {
-		if seller.Contact == nil {
-			seller.Contact = &ContactDetails{}
-		}
-		seller.Contact.Email = supplier.Emails[0].Address
+	seller.Address, seller.CorrespondenceAddress = newPartyAddresses(supplier)
+	if status := supplier.Ext.Get(ExtKeyTaxpayerStatus); status != "" {
+		seller.TaxpayerStatus, _ = strconv.Atoi(status.String())
 	}
+	seller.Contacts = newContactDetails(supplier)
 
 	return seller
 }
@@ -164,21 +184,11 @@ func NewFavatBuyer(customer *org.Party) *Buyer {
 		}
 	}
 
-	if len(customer.Addresses) > 0 {
-		buyer.Address = newAddress(customer.Addresses[0])
-	}
+	buyer.EORI = partyIdentityType(customer, IdentityTypeEORI)
+	buyer.Address, buyer.CorrespondenceAddress = newPartyAddresses(customer)
+	buyer.CustomerNumber = customerNumber(customer)
 
-	if len(customer.Telephones) > 0 {
-		buyer.Contact = &ContactDetails{
-			Phone: customer.Telephones[0].Number,
-		}
-	}
-	if len(customer.Emails) > 0 {
-		if buyer.Contact == nil {
-			buyer.Contact = &ContactDetails{}
-		}
-		buyer.Contact.Email = customer.Emails[0].Address
-	}
+	buyer.Contacts = newContactDetails(customer)
 
 	if customer.Name != "" {
 		buyer.Name = customer.Name
@@ -194,46 +204,130 @@ func NewFavatBuyer(customer *org.Party) *Buyer {
 	return buyer
 }
 
-func addressLine1(address *org.Address) string {
-	line1 := address.Street
-	if address.Number != "" {
-		line1 += " " + address.Number
-	}
-	if address.Block != "" {
-		line1 += " " + address.Block
+// newContactDetails returns the emails and telephones of the party, up to
+// the maximum number of contact details. Emails and telephones with the same
+// label share the contact details, and the others are paired in order.
+func newContactDetails(party *org.Party) []*ContactDetails {
+	var contacts []*ContactDetails
+	labels := make(map[*ContactDetails]string)
+	for _, e := range party.Emails {
+		if len(contacts) == maxContacts {
+			break
+		}
+		c := &ContactDetails{Email: e.Address}
+		contacts = append(contacts, c)
+		labels[c] = e.Label
+	}
+
+	var phones []*org.Telephone
+	for _, t := range party.Telephones {
+		paired := false
+		for _, c := range contacts {
+			if t.Label != "" && c.Phone == "" && labels[c] == t.Label {
+				c.Phone = t.Number
+				paired = true
+				break
+			}
+		}
+		if !paired {
+			phones = append(phones, t)
+		}
 	}
-	if address.Floor != "" {
-		line1 += " " + address.Floor
+	for _, t := range phones {
+		var contact *ContactDetails
+		for _, c := range contacts {
+			if c.Phone == "" {
+				contact = c
+				break
+			}
+		}
+		if contact == nil {
+			if len(contacts) == maxContacts {
+				break
+			}
+			contact = new(ContactDetails)
+			contacts = append(contacts, contact)
+		}
+		contact.Phone = t.Number
 	}
-	if address.Door != "" {
-		line1 += " " + address.Door
+
+	return contacts
+}
+
+// parseContactDetails returns the emails and telephones of all the contact
+// details.
+func parseContactDetails(contacts []*ContactDetails) ([]*org.Email, []*org.Telephone) {
+	var emails []*org.Email
+	var phones []*org.Telephone
+	for _, c := range contacts {
+		if c.Email != "" {
+			emails = append(emails, &org.Email{Address: c.Email})
+		}
+		if c.Phone != "" {
+			phones = append(phones, &org.Telephone{Number: c.Phone})
+		}
 	}
+	return emails, phones
+}
 
-	if address.Code.String() != "" {
-		line1 += ", " + address.Code.String()
+// newPartyAddresses returns the main address of the party, with the GLN of
+// the party if any, and its correspondence address, which is the second one.
+func newPartyAddresses(party *org.Party) (*Address, *Address) {
+	var main, correspondence *Address
+	if len(party.Addresses) > 0 {
+		main = newAddress(party.Addresses[0])
+		if id := org.IdentityForKey(party.Identities, org.IdentityKeyGLN); id != nil {
+			main.GLN = id.Code.String()
+		}
 	}
-	if address.Locality != "" {
-		line1 += ", " + address.Locality
+	if len(party.Addresses) > 1 {
+		correspondence = newAddress(party.Addresses[1])
 	}
+	return main, correspondence
+}
 
-	return line1
+func partyIdentityType(party *org.Party, typ cbc.Code) string {
+	if id := org.IdentityForType(party.Identities, typ); id != nil {
+		return id.Code.String()
+	}
+	return ""
 }
 
+// NewThirdParties converts the invoice parties other than the supplier and
+// customer into KSeF third parties: the payee (factor), the delivery receiver
+// (recipient), and the ordering seller (original entity), issuer and buyer
+// (payer). A third party role extension on the party overrides its default
+// role. The supplier and customer identities with a role, like those of JST
+// or VAT group members, are added afterwards.
 func NewThirdParties(invoice *bill.Invoice) []*ThirdParty {
-	thirdParties := make([]*ThirdParty, 0, 100)
+	thirdParties := make([]*ThirdParty, 0, maxThirdParties)
 
-	// TODO: Reading from identities work for third parties like Group VAT or JST. However, for other third parties like issuer or recipient should be mapped from another GOBL structure.
-	if len(invoice.Supplier.Identities) > 0 {
-		thirdParty := newThirdPartyFromIdentity(invoice.Supplier.Identities[0])
-		if thirdParty != nil {
-			thirdParties = append(thirdParties, thirdParty)
+	add := func(party *org.Party, role string) {
+		if party != nil {
+			thirdParties = append(thirdParties, newThirdParty(party, role))
+		}
+	}
+	if invoice.Payment != nil {
+		add(invoice.Payment.Payee, thirdPartyRoleFactor)
+	}
+	if invoice.Delivery != nil && isIdentifiedParty(invoice.Delivery.Receiver) {
+		add(invoice.Delivery.Receiver, thirdPartyRoleRecipient)
+	}
+	if invoice.Ordering != nil {
+		add(invoice.Ordering.Seller, thirdPartyRoleOriginal)
+		if issuer := invoice.Ordering.Issuer; issuer != nil && issuer.Ext.Get(ExtKeyAuthorizedRole) == "" {
+			add(issuer, thirdPartyRoleIssuer)
 		}
+		add(invoice.Ordering.Buyer, thirdPartyRolePayer)
 	}
 
-	if invoice.Customer != nil {
-		if len(invoice.Customer.Identities) > 0 {
-			thirdParty := newThirdPartyFromIdentity(invoice.Customer.Identities[0])
-			if thirdParty != nil {
+	// Every supplier and customer identity with a role is a third party
+	for _, party := range []*org.Party{invoice.Supplier, invoice.Customer} {
+		if party == nil {
+			continue
+		}
+		for _, id := range party.Identities {
+			if thirdParty := newThirdPartyFromIdentity(id); thirdParty != nil {
 				thirdParties = append(thirdParties, thirdParty)
 			}
 		}
@@ -242,18 +336,63 @@ func NewThirdParties(invoice *bill.Invoice) []*ThirdParty {
 	return thirdParties
 }
 
-func newThirdPartyFromIdentity(identity *org.Identity) *ThirdParty {
+// validateThirdParties checks that the invoice parties and identities
+// reported as third parties do not exceed the schema limit.
+func validateThirdParties(inv *bill.Invoice) error {
+	if n := len(NewThirdParties(inv)); n > maxThirdParties {
+		return fmt.Errorf("%d third parties, maximum is %d", n, maxThirdParties)
+	}
+	return nil
+}
 
-	if identity.Ext == nil || identity.Ext.Get(favat.ExtKeyThirdPartyRole) == "" {
-		return nil
+// isIdentifiedParty is true for parties with a name or tax ID. Delivery
+// receivers with only an address are mapped to the transport destination.
+func isIdentifiedParty(party *org.Party) bool {
+	return party != nil && (party.Name != "" || party.TaxID != nil)
+}
+
+func newThirdParty(party *org.Party, role string) *ThirdParty {
+	thirdParty := &ThirdParty{
+		EORI:     partyIdentityType(party, IdentityTypeEORI),
+		Name:     party.Name,
+		Contacts: newContactDetails(party),
 	}
+	thirdParty.Address, thirdParty.CorrespondenceAddress = newPartyAddresses(party)
+
+	if id := org.IdentityForKey(party.Identities, IdentityKeyInternalID); id != nil {
+		thirdParty.InternalID = id.Code.String()
+	} else if party.TaxID != nil && party.TaxID.Code != "" {
+		thirdParty.setID(party.TaxID.Country.Code(), party.TaxID.Code.String())
+	} else {
+		thirdParty.NoID = 1
+	}
+
+	if r := party.Ext.Get(favat.ExtKeyThirdPartyRole); r != "" {
+		role = r.String()
+	}
+	if party.Ext.Get(ExtKeyOtherRole) != "" {
+		thirdParty.OtherRole = 1
+		thirdParty.OtherRoleDescription = party.Label
+	} else {
+		thirdParty.Role = role
+	}
+
+	if party.Meta != nil {
+		thirdParty.Share = party.Meta[MetaKeyShare]
+	}
+	thirdParty.CustomerNumber = customerNumber(party)
+
+	return thirdParty
+}
 
+func newThirdPartyFromIdentity(identity *org.Identity) *ThirdParty {
 	role := identity.Ext.Get(favat.ExtKeyThirdPartyRole)
 	if role == "" {
 		return nil
 	}
 
 	thirdParty := &ThirdParty{
+		Name: identity.Description,
 		Role: role.String(),
 	}
 
@@ -261,24 +400,72 @@ func newThirdPartyFromIdentity(identity *org.Identity) *ThirdParty {
 		thirdParty.NoID = 1
 		return thirdParty
 	}
+	thirdParty.setID(identity.Country.Code(), identity.Code.String())
 
-	if identity.Country == l10n.PL.ISO() {
-		thirdParty.NIP = identity.Code.String()
-		return thirdParty
+	return thirdParty
+}
+
+// setID sets the Polish NIP, the EU VAT number or the foreign tax ID of the
+// third party.
+func (tp *ThirdParty) setID(country l10n.Code, code string) {
+	switch {
+	case country == l10n.PL:
+		tp.NIP = code
+	case l10n.Union(l10n.EU).HasMember(country):
+		tp.UECode = country.String()
+		tp.UEVatNumber = code
+	default:
+		tp.IDNumber = code
+		if country != "" {
+			tp.CountryCode = country.String()
+		}
 	}
+}
 
-	if l10n.Union(l10n.EU).HasMember(identity.Country.Code()) {
-		thirdParty.UECode = identity.Country.String()
-		thirdParty.UEVatNumber = identity.Code.String()
-		return thirdParty
+// NewAuthorizedEntity converts the invoice issuer into a KSeF authorized entity,
+// provided it carries the authorized role extension. Returns nil otherwise, and
+// an error when the role is invalid or the NIP, name or address is missing.
+func NewAuthorizedEntity(invoice *bill.Invoice) (*AuthorizedEntity, error) {
+	if invoice.Ordering == nil || invoice.Ordering.Issuer == nil {
+		return nil, nil
+	}
+	issuer := invoice.Ordering.Issuer
+	code := issuer.Ext.Get(ExtKeyAuthorizedRole)
+	if code == "" {
+		return nil, nil
 	}
 
-	thirdParty.IDNumber = identity.Code.String()
-	if identity.Country != "" {
-		thirdParty.CountryCode = identity.Country.String()
+	if !tax.ExtensionForKey(ExtKeyAuthorizedRole).HasCode(code) {
+		return nil, fmt.Errorf("authorized entity: invalid role '%s'", code)
+	}
+	if issuer.TaxID == nil || issuer.TaxID.Code == "" {
+		return nil, fmt.Errorf("authorized entity: missing NIP")
+	}
+	if issuer.Name == "" {
+		return nil, fmt.Errorf("authorized entity: missing name")
+	}
+	if len(issuer.Addresses) == 0 {
+		return nil, fmt.Errorf("authorized entity: missing address")
 	}
 
-	return thirdParty
+	role, err := strconv.Atoi(code.String())
+	if err != nil {
+		return nil, fmt.Errorf("authorized entity: invalid role '%s': %w", code, err)
+	}
+	entity := &AuthorizedEntity{
+		NIP:     issuer.TaxID.Code.String(),
+		Name:    issuer.Name,
+		Address: newAddress(issuer.Addresses[0]),
+		Role:    role,
+	}
+	if len(issuer.Emails) > 0 {
+		entity.Email = issuer.Emails[0].Address
+	}
+	if len(issuer.Telephones) > 0 {
+		entity.Phone = issuer.Telephones[0].Number
+	}
+
+	return entity, nil
 }
 
 // ToGOBL converts a KSEF Seller to a GOBL Party (supplier).
@@ -299,21 +486,18 @@ func (s *Seller) ToGOBL() *org.Party {
 		}
 	}
 
-	// Parse address
-	if s.Address != nil {
-		party.Addresses = []*org.Address{parseAddress(s.Address)}
-	}
+	party.Identities = parsePartyIdentities(s.EORI, s.Address)
+	party.Addresses = parsePartyAddresses(s.Address, s.CorrespondenceAddress)
 
-	// Parse contact details
-	if s.Contact != nil {
-		if s.Contact.Email != "" {
-			party.Emails = []*org.Email{{Address: s.Contact.Email}}
-		}
-		if s.Contact.Phone != "" {
-			party.Telephones = []*org.Telephone{{Number: s.Contact.Phone}}
+	if s.TaxpayerStatus != 0 {
+		party.Ext = tax.Extensions{
+			ExtKeyTaxpayerStatus: cbc.Code(strconv.Itoa(s.TaxpayerStatus)),
 		}
 	}
 
+	// Parse contact details
+	party.Emails, party.Telephones = parseContactDetails(s.Contacts)
+
 	return party
 }
 
@@ -328,42 +512,14 @@ func (b *Buyer) ToGOBL() *org.Party {
 		Name: b.Name,
 	}
 
-	// Parse tax ID
-	if b.NIP != "" {
-		party.TaxID = &tax.Identity{
-			Country: l10n.PL.Tax(),
-			Code:    cbc.Code(b.NIP),
-		}
-	} else if b.UEVatNumber != "" && b.UECode != "" {
-		party.TaxID = &tax.Identity{
-			Country: l10n.Code(b.UECode).Tax(),
-			Code:    cbc.Code(b.UEVatNumber),
-		}
-	} else if b.IDNumber != "" {
-		country := l10n.PL.Tax()
-		if b.CountryCode != "" {
-			country = l10n.Code(b.CountryCode).Tax()
-		}
-		party.TaxID = &tax.Identity{
-			Country: country,
-			Code:    cbc.Code(b.IDNumber),
-		}
-	}
+	party.TaxID = parseTaxID(b.NIP, b.UECode, b.UEVatNumber, b.CountryCode, b.IDNumber)
 
-	// Parse address
-	if b.Address != nil {
-		party.Addresses = []*org.Address{parseAddress(b.Address)}
-	}
+	party.Identities = parsePartyIdentities(b.EORI, b.Address)
+	setCustomerNumber(party, b.CustomerNumber)
+	party.Addresses = parsePartyAddresses(b.Address, b.CorrespondenceAddress)
 
 	// Parse contact details
-	if b.Contact != nil {
-		if b.Contact.Email != "" {
-			party.Emails = []*org.Email{{Address: b.Contact.Email}}
-		}
-		if b.Contact.Phone != "" {
-			party.Telephones = []*org.Telephone{{Number: b.Contact.Phone}}
-		}
-	}
+	party.Emails, party.Telephones = parseContactDetails(b.Contacts)
 
 	// Parse extensions
 	if b.JST == "1" || b.GV == "1" {
@@ -383,6 +539,171 @@ func (b *Buyer) ToGOBL() *org.Party {
 	return party
 }
 
+// parseTaxID returns the tax ID of a buyer or third party from its Polish
+// NIP, its EU VAT number or its other tax ID, which is Polish unless the
+// country is given.
+func parseTaxID(nip, ueCode, ueVatNumber, countryCode, idNumber string) *tax.Identity {
+	switch {
+	case nip != "":
+		return &tax.Identity{
+			Country: l10n.PL.Tax(),
+			Code:    cbc.Code(nip),
+		}
+	case ueVatNumber != "" && ueCode != "":
+		return &tax.Identity{
+			Country: l10n.Code(ueCode).Tax(),
+			Code:    cbc.Code(ueVatNumber),
+		}
+	case idNumber != "":
+		country := l10n.PL.Tax()
+		if countryCode != "" {
+			country = l10n.Code(countryCode).Tax()
+		}
+		return &tax.Identity{
+			Country: country,
+			Code:    cbc.Code(idNumber),
+		}
+	}
+	return nil
+}
+
+// parsePartyIdentities returns the EORI number and the GLN of the address of
+// a party as GOBL identities.
+func parsePartyIdentities(eori string, addr *Address) []*org.Identity {
+	var ids []*org.Identity
+	if eori != "" {
+		ids = append(ids, &org.Identity{
+			Type: IdentityTypeEORI,
+			Code: cbc.Code(eori),
+		})
+	}
+	if addr != nil && addr.GLN != "" {
+		ids = append(ids, &org.Identity{
+			Key:  org.IdentityKeyGLN,
+			Code: cbc.Code(addr.GLN),
+		})
+	}
+	return ids
+}
+
+// parsePartyAddresses returns the main address of a party followed by its
+// correspondence address.
+func parsePartyAddresses(addr, correspondence *Address) []*org.Address {
+	var addresses []*org.Address
+	if addr != nil {
+		addresses = append(addresses, parseAddress(addr))
+	}
+	if correspondence != nil {
+		addresses = append(addresses, parseAddress(correspondence))
+	}
+	return addresses
+}
+
+// ToGOBL converts a KSEF AuthorizedEntity to a GOBL Party (issuer).
+func (a *AuthorizedEntity) ToGOBL() *org.Party {
+	party := &org.Party{
+		Name: a.Name,
+		Ext: tax.Extensions{
+			ExtKeyAuthorizedRole: cbc.Code(strconv.Itoa(a.Role)),
+		},
+	}
+
+	if a.NIP != "" {
+		party.TaxID = &tax.Identity{
+			Country: l10n.PL.Tax(),
+			Code:    cbc.Code(a.NIP),
+		}
+	}
+
+	if a.Address != nil {
+		party.Addresses = []*org.Address{parseAddress(a.Address)}
+	}
+
+	if a.Email != "" {
+		party.Emails = []*org.Email{{Address: a.Email}}
+	}
+	if a.Phone != "" {
+		party.Telephones = []*org.Telephone{{Number: a.Phone}}
+	}
+
+	return party
+}
+
+// ToGOBL converts a KSEF ThirdParty to a GOBL Party. The role is set by the
+// invoice party it is parsed into, except for other roles.
+func (tp *ThirdParty) ToGOBL() *org.Party {
+	party := &org.Party{
+		Name: tp.Name,
+	}
+
+	party.TaxID = parseTaxID(tp.NIP, tp.UECode, tp.UEVatNumber, tp.CountryCode, tp.IDNumber)
+
+	party.Identities = parsePartyIdentities(tp.EORI, tp.Address)
+	if tp.InternalID != "" {
+		party.Identities = append(party.Identities, &org.Identity{
+			Key:  IdentityKeyInternalID,
+			Code: cbc.Code(tp.InternalID),
+		})
+	}
+	setCustomerNumber(party, tp.CustomerNumber)
+	party.Addresses = parsePartyAddresses(tp.Address, tp.CorrespondenceAddress)
+
+	// Parse contact details
+	party.Emails, party.Telephones = parseContactDetails(tp.Contacts)
+
+	if tp.OtherRole == 1 {
+		party.Label = tp.OtherRoleDescription
+		party.Ext = tax.Extensions{ExtKeyOtherRole: "1"}
+	}
+	if tp.Share != "" {
+		if party.Meta == nil {
+			party.Meta = make(cbc.Meta)
+		}
+		party.Meta[MetaKeyShare] = tp.Share
+	}
+
+	return party
+}
+
+// customerNumber returns the customer number of the party, taken from its
+// identity or else its meta.
+func customerNumber(party *org.Party) string {
+	number := party.Meta[MetaKeyCustomerNumber]
+	if id := org.IdentityForKey(party.Identities, IdentityKeyCustomerNumber); id != nil {
+		number = id.Code.String()
+	}
+	return truncate(number, maxCustomerNumber)
+}
+
+// setCustomerNumber adds the customer number to the party as an identity, or
+// to its meta when it is not a valid code.
+func setCustomerNumber(party *org.Party, number string) {
+	if number == "" {
+		return
+	}
+	if !isCode(number) {
+		if party.Meta == nil {
+			party.Meta = make(cbc.Meta)
+		}
+		party.Meta[MetaKeyCustomerNumber] = number
+		return
+	}
+	party.Identities = append(party.Identities, &org.Identity{
+		Key:  IdentityKeyCustomerNumber,
+		Code: cbc.Code(number),
+	})
+}
+
+// isIdentityRole is true for the roles of JST and VAT group members, which
+// are mapped to identities of the supplier or customer.
+func (tp *ThirdParty) isIdentityRole() bool {
+	switch tp.Role {
+	case "7", "8", "9", "10":
+		return true
+	}
+	return false
+}
+
 // toIdentity converts a KSEF ThirdParty to a GOBL Identity.
 func (tp *ThirdParty) toIdentity() *org.Identity {
 	if tp.NoID == 1 {
@@ -416,16 +737,3 @@ func (tp *ThirdParty) toIdentity() *org.Identity {
 
 	return identity
 }
-
-// parseAddress converts a KSEF Address to a GOBL Address.
-func parseAddress(addr *Address) *org.Address {
-	addressLine := addr.AddressL1
-	if addr.AddressL2 != "" {
-		addressLine += " " + addr.AddressL2
-	}
-
-	return &org.Address{
-		Country: l10n.ISOCountryCode(addr.CountryCode),
-		Street:  addressLine,
-	}
-}
@@ -1,16 +1,23 @@
 package ksef_test
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"unicode/utf8"
 
 	ksef "github.com/invopop/gobl.ksef"
+	"github.com/invopop/gobl.ksef/test"
 	"github.com/invopop/gobl/addons/pl/favat"
 	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
 	"github.com/invopop/gobl/l10n"
 	"github.com/invopop/gobl/org"
 	"github.com/invopop/gobl/tax"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewFavatSeller(t *testing.T) {
@@ -41,7 +48,7 @@ func TestNewFavatSeller(t *testing.T) {
 		assert.Equal(t, "PL", seller.Address.CountryCode)
 		assert.Contains(t, seller.Address.AddressL1, "ul. Testowa")
 		assert.Contains(t, seller.Address.AddressL1, "123")
-		assert.Nil(t, seller.Contact)
+		assert.Empty(t, seller.Contacts)
 	})
 
 	t.Run("creates seller with phone number", func(t *testing.T) {
@@ -67,9 +74,9 @@ func TestNewFavatSeller(t *testing.T) {
 
 		seller := ksef.NewFavatSeller(supplier)
 
-		assert.NotNil(t, seller.Contact)
-		assert.Equal(t, "+48 123 456 789", seller.Contact.Phone)
-		assert.Empty(t, seller.Contact.Email)
+		require.Len(t, seller.Contacts, 1)
+		assert.Equal(t, "+48 123 456 789", seller.Contacts[0].Phone)
+		assert.Empty(t, seller.Contacts[0].Email)
 	})
 
 	t.Run("creates seller with email", func(t *testing.T) {
@@ -95,9 +102,9 @@ func TestNewFavatSeller(t *testing.T) {
 
 		seller := ksef.NewFavatSeller(supplier)
 
-		assert.NotNil(t, seller.Contact)
-		assert.Equal(t, "contact@testcompany.pl", seller.Contact.Email)
-		assert.Empty(t, seller.Contact.Phone)
+		require.Len(t, seller.Contacts, 1)
+		assert.Equal(t, "contact@testcompany.pl", seller.Contacts[0].Email)
+		assert.Empty(t, seller.Contacts[0].Phone)
 	})
 
 	t.Run("creates seller with both phone and email", func(t *testing.T) {
@@ -126,14 +133,14 @@ func TestNewFavatSeller(t *testing.T) {
 
 		seller := ksef.NewFavatSeller(supplier)
 
-		assert.NotNil(t, seller.Contact)
-		assert.Equal(t, "+48 123 456 789", seller.Contact.Phone)
-		assert.Equal(t, "contact@testcompany.pl", seller.Contact.Email)
+		require.Len(t, seller.Contacts, 1)
+		assert.Equal(t, "+48 123 456 789", seller.Contacts[0].Phone)
+		assert.Equal(t, "contact@testcompany.pl", seller.Contacts[0].Email)
 	})
 
-	t.Run("splits long address into two lines", func(t *testing.T) {
+	t.Run("wraps long street into the second line", func(t *testing.T) {
 		// Create a street name that will exceed 512 characters when combined
-		longStreet := strings.Repeat("a", 500)
+		longStreet := strings.Repeat("ą", 500) + " " + strings.Repeat("ż", 20)
 		supplier := &org.Party{
 			Name: "Test Company Sp. z o.o.",
 			TaxID: &tax.Identity{
@@ -153,11 +160,12 @@ func TestNewFavatSeller(t *testing.T) {
 
 		seller := ksef.NewFavatSeller(supplier)
 
-		assert.Len(t, seller.Address.AddressL1, 512)
-		assert.NotEmpty(t, seller.Address.AddressL2)
+		assert.Equal(t, strings.Repeat("ą", 500), seller.Address.AddressL1)
+		assert.Equal(t, strings.Repeat("ż", 20)+" 123, 00-001 Warszawa", seller.Address.AddressL2)
+		assert.True(t, utf8.ValidString(seller.Address.AddressL1))
 	})
 
-	t.Run("does not set AddressL2 when address is under 512 chars", func(t *testing.T) {
+	t.Run("sets postcode and locality in AddressL2", func(t *testing.T) {
 		supplier := &org.Party{
 			Name: "Test Company Sp. z o.o.",
 			TaxID: &tax.Identity{
@@ -177,10 +185,11 @@ func TestNewFavatSeller(t *testing.T) {
 
 		seller := ksef.NewFavatSeller(supplier)
 
-		assert.Empty(t, seller.Address.AddressL2)
+		assert.Equal(t, "ul. Testowa 123", seller.Address.AddressL1)
+		assert.Equal(t, "00-001 Warszawa", seller.Address.AddressL2)
 	})
 
-	t.Run("uses first phone when multiple are present", func(t *testing.T) {
+	t.Run("emits all phones when multiple are present", func(t *testing.T) {
 		supplier := &org.Party{
 			Name: "Test Company Sp. z o.o.",
 			TaxID: &tax.Identity{
@@ -204,10 +213,12 @@ func TestNewFavatSeller(t *testing.T) {
 
 		seller := ksef.NewFavatSeller(supplier)
 
-		assert.Equal(t, "+48 111 111 111", seller.Contact.Phone)
+		require.Len(t, seller.Contacts, 2)
+		assert.Equal(t, "+48 111 111 111", seller.Contacts[0].Phone)
+		assert.Equal(t, "+48 222 222 222", seller.Contacts[1].Phone)
 	})
 
-	t.Run("uses first email when multiple are present", func(t *testing.T) {
+	t.Run("emits all emails when multiple are present", func(t *testing.T) {
 		supplier := &org.Party{
 			Name: "Test Company Sp. z o.o.",
 			TaxID: &tax.Identity{
@@ -231,7 +242,9 @@ func TestNewFavatSeller(t *testing.T) {
 
 		seller := ksef.NewFavatSeller(supplier)
 
-		assert.Equal(t, "first@testcompany.pl", seller.Contact.Email)
+		require.Len(t, seller.Contacts, 2)
+		assert.Equal(t, "first@testcompany.pl", seller.Contacts[0].Email)
+		assert.Equal(t, "second@testcompany.pl", seller.Contacts[1].Email)
 	})
 }
 
@@ -379,9 +392,9 @@ func TestNewFavatBuyer(t *testing.T) {
 
 		buyer := ksef.NewFavatBuyer(customer)
 
-		assert.NotNil(t, buyer.Contact)
-		assert.Equal(t, "+48 987 654 321", buyer.Contact.Phone)
-		assert.Empty(t, buyer.Contact.Email)
+		require.Len(t, buyer.Contacts, 1)
+		assert.Equal(t, "+48 987 654 321", buyer.Contacts[0].Phone)
+		assert.Empty(t, buyer.Contacts[0].Email)
 	})
 
 	t.Run("creates buyer with email", func(t *testing.T) {
@@ -398,9 +411,9 @@ func TestNewFavatBuyer(t *testing.T) {
 
 		buyer := ksef.NewFavatBuyer(customer)
 
-		assert.NotNil(t, buyer.Contact)
-		assert.Equal(t, "buyer@example.pl", buyer.Contact.Email)
-		assert.Empty(t, buyer.Contact.Phone)
+		require.Len(t, buyer.Contacts, 1)
+		assert.Equal(t, "buyer@example.pl", buyer.Contacts[0].Email)
+		assert.Empty(t, buyer.Contacts[0].Phone)
 	})
 
 	t.Run("creates buyer with both phone and email", func(t *testing.T) {
@@ -420,12 +433,12 @@ func TestNewFavatBuyer(t *testing.T) {
 
 		buyer := ksef.NewFavatBuyer(customer)
 
-		assert.NotNil(t, buyer.Contact)
-		assert.Equal(t, "+48 987 654 321", buyer.Contact.Phone)
-		assert.Equal(t, "buyer@example.pl", buyer.Contact.Email)
+		require.Len(t, buyer.Contacts, 1)
+		assert.Equal(t, "+48 987 654 321", buyer.Contacts[0].Phone)
+		assert.Equal(t, "buyer@example.pl", buyer.Contacts[0].Email)
 	})
 
-	t.Run("uses first phone when multiple are present", func(t *testing.T) {
+	t.Run("emits all phones when multiple are present", func(t *testing.T) {
 		customer := &org.Party{
 			Name: "Test Buyer",
 			TaxID: &tax.Identity{
@@ -440,10 +453,12 @@ func TestNewFavatBuyer(t *testing.T) {
 
 		buyer := ksef.NewFavatBuyer(customer)
 
-		assert.Equal(t, "+48 111 111 111", buyer.Contact.Phone)
+		require.Len(t, buyer.Contacts, 2)
+		assert.Equal(t, "+48 111 111 111", buyer.Contacts[0].Phone)
+		assert.Equal(t, "+48 222 222 222", buyer.Contacts[1].Phone)
 	})
 
-	t.Run("uses first email when multiple are present", func(t *testing.T) {
+	t.Run("emits all emails when multiple are present", func(t *testing.T) {
 		customer := &org.Party{
 			Name: "Test Buyer",
 			TaxID: &tax.Identity{
@@ -458,7 +473,9 @@ func TestNewFavatBuyer(t *testing.T) {
 
 		buyer := ksef.NewFavatBuyer(customer)
 
-		assert.Equal(t, "first@example.pl", buyer.Contact.Email)
+		require.Len(t, buyer.Contacts, 2)
+		assert.Equal(t, "first@example.pl", buyer.Contacts[0].Email)
+		assert.Equal(t, "second@example.pl", buyer.Contacts[1].Email)
 	})
 
 	t.Run("buyer without address has nil Address field", func(t *testing.T) {
@@ -760,7 +777,7 @@ func TestNewThirdParties(t *testing.T) {
 		assert.Len(t, thirdParties, 1)
 	})
 
-	t.Run("uses only first supplier identity", func(t *testing.T) {
+	t.Run("uses all supplier identities with a role", func(t *testing.T) {
 		inv := baseInvoice()
 		inv.Supplier.Identities = []*org.Identity{
 			{
@@ -781,12 +798,14 @@ func TestNewThirdParties(t *testing.T) {
 
 		thirdParties := ksef.NewThirdParties(inv)
 
-		assert.Len(t, thirdParties, 1)
+		require.Len(t, thirdParties, 2)
 		assert.Equal(t, "1111111111", thirdParties[0].NIP)
 		assert.Equal(t, "1", thirdParties[0].Role)
+		assert.Equal(t, "2222222222", thirdParties[1].NIP)
+		assert.Equal(t, "2", thirdParties[1].Role)
 	})
 
-	t.Run("uses only first customer identity", func(t *testing.T) {
+	t.Run("uses all customer identities with a role", func(t *testing.T) {
 		inv := baseInvoice()
 		inv.Customer = &org.Party{
 			Name: "Test Customer",
@@ -810,9 +829,11 @@ func TestNewThirdParties(t *testing.T) {
 
 		thirdParties := ksef.NewThirdParties(inv)
 
-		assert.Len(t, thirdParties, 1)
+		require.Len(t, thirdParties, 2)
 		assert.Equal(t, "1111111111", thirdParties[0].NIP)
 		assert.Equal(t, "8", thirdParties[0].Role)
+		assert.Equal(t, "2222222222", thirdParties[1].NIP)
+		assert.Equal(t, "10", thirdParties[1].Role)
 	})
 
 	t.Run("Spanish EU identity sets UECode and UEVatNumber", func(t *testing.T) {
@@ -833,6 +854,153 @@ func TestNewThirdParties(t *testing.T) {
 		assert.Equal(t, "ES", thirdParties[0].UECode)
 		assert.Equal(t, "B12345678", thirdParties[0].UEVatNumber)
 	})
+
+	t.Run("finds the third party among other supplier identities", func(t *testing.T) {
+		inv := baseInvoice()
+		inv.Supplier.Identities = []*org.Identity{
+			{Type: ksef.IdentityTypeEORI, Code: "PL987654321000000"},
+			{
+				Code:    "1111111111",
+				Country: l10n.PL.ISO(),
+				Ext: tax.Extensions{
+					favat.ExtKeyThirdPartyRole: "1",
+				},
+			},
+		}
+
+		thirdParties := ksef.NewThirdParties(inv)
+
+		assert.Len(t, thirdParties, 1)
+		assert.Equal(t, "1111111111", thirdParties[0].NIP)
+	})
+
+	t.Run("maps the payee as factor", func(t *testing.T) {
+		inv := baseInvoice()
+		inv.Payment = &bill.PaymentDetails{
+			Payee: &org.Party{
+				Name:  "Faktoring Polska S.A.",
+				TaxID: &tax.Identity{Country: l10n.PL.Tax(), Code: "7740001454"},
+				Addresses: []*org.Address{
+					{Street: "ul. Finansowa", Number: "10", Code: "00-001", Locality: "Warszawa", Country: "PL"},
+				},
+				Emails: []*org.Email{{Address: "faktoring@example.pl"}},
+			},
+		}
+
+		thirdParties := ksef.NewThirdParties(inv)
+
+		require.Len(t, thirdParties, 1)
+		tp := thirdParties[0]
+		assert.Equal(t, "1", tp.Role)
+		assert.Equal(t, "7740001454", tp.NIP)
+		assert.Equal(t, "Faktoring Polska S.A.", tp.Name)
+		require.NotNil(t, tp.Address)
+		assert.Equal(t, "ul. Finansowa 10", tp.Address.AddressL1)
+		require.Len(t, tp.Contacts, 1)
+		assert.Equal(t, "faktoring@example.pl", tp.Contacts[0].Email)
+	})
+
+	t.Run("maps the delivery receiver with an internal ID as recipient", func(t *testing.T) {
+		inv := baseInvoice()
+		inv.Delivery = &bill.DeliveryDetails{
+			Receiver: &org.Party{
+				Name: "Oddział Gdynia",
+				Identities: []*org.Identity{
+					{Key: ksef.IdentityKeyInternalID, Code: "1111111111-00002"},
+				},
+			},
+		}
+
+		thirdParties := ksef.NewThirdParties(inv)
+
+		require.Len(t, thirdParties, 1)
+		assert.Equal(t, "2", thirdParties[0].Role)
+		assert.Equal(t, "1111111111-00002", thirdParties[0].InternalID)
+		assert.Empty(t, thirdParties[0].NIP)
+	})
+
+	t.Run("skips delivery receivers with only an address", func(t *testing.T) {
+		inv := baseInvoice()
+		inv.Delivery = &bill.DeliveryDetails{
+			Receiver: &org.Party{
+				Addresses: []*org.Address{{Street: "ul. Portowa", Locality: "Gdynia", Country: "PL"}},
+			},
+		}
+
+		assert.Empty(t, ksef.NewThirdParties(inv))
+	})
+
+	t.Run("maps the ordering parties", func(t *testing.T) {
+		inv := baseInvoice()
+		inv.Ordering = &bill.Ordering{
+			Seller: &org.Party{Name: "Podmiot Pierwotny"},
+			Issuer: &org.Party{Name: "Biuro Rachunkowe"},
+			Buyer:  &org.Party{Name: "Płatnik"},
+		}
+
+		thirdParties := ksef.NewThirdParties(inv)
+
+		require.Len(t, thirdParties, 3)
+		assert.Equal(t, "3", thirdParties[0].Role)
+		assert.Equal(t, 1, thirdParties[0].NoID)
+		assert.Equal(t, "5", thirdParties[1].Role)
+		assert.Equal(t, "6", thirdParties[2].Role)
+	})
+
+	t.Run("skips the issuer with an authorized role", func(t *testing.T) {
+		inv := baseInvoice()
+		inv.Ordering = &bill.Ordering{
+			Issuer: &org.Party{
+				Name: "Komornik Sądowy",
+				Ext:  tax.Extensions{ksef.ExtKeyAuthorizedRole: "2"},
+			},
+		}
+
+		assert.Empty(t, ksef.NewThirdParties(inv))
+	})
+
+	t.Run("uses the party role, other role and share", func(t *testing.T) {
+		inv := baseInvoice()
+		inv.Ordering = &bill.Ordering{
+			Buyer: &org.Party{
+				Name: "Dodatkowy Nabywca",
+				Meta: cbc.Meta{ksef.MetaKeyShare: "30"},
+				Ext:  tax.Extensions{favat.ExtKeyThirdPartyRole: "4"},
+			},
+			Seller: &org.Party{
+				Label: "Agent rozliczeniowy",
+				Name:  "Rozliczenia Sp. z o.o.",
+				Ext:   tax.Extensions{ksef.ExtKeyOtherRole: "1"},
+			},
+		}
+
+		thirdParties := ksef.NewThirdParties(inv)
+
+		require.Len(t, thirdParties, 2)
+		assert.Empty(t, thirdParties[0].Role)
+		assert.Equal(t, 1, thirdParties[0].OtherRole)
+		assert.Equal(t, "Agent rozliczeniowy", thirdParties[0].OtherRoleDescription)
+		assert.Equal(t, "4", thirdParties[1].Role)
+		assert.Equal(t, "30", thirdParties[1].Share)
+	})
+}
+
+func TestValidateThirdParties(t *testing.T) {
+	t.Run("rejects more than 100 third parties", func(t *testing.T) {
+		env, err := test.LoadTestEnvelope("invoice-standard.json")
+		require.NoError(t, err)
+		inv := env.Extract().(*bill.Invoice)
+		for i := 0; i < 101; i++ {
+			inv.Supplier.Identities = append(inv.Supplier.Identities, &org.Identity{
+				Code:    cbc.Code(fmt.Sprintf("52522484%02d", i)),
+				Country: l10n.PL.ISO(),
+				Ext:     tax.Extensions{favat.ExtKeyThirdPartyRole: "9"},
+			})
+		}
+
+		_, err = ksef.BuildFavat(env)
+		assert.ErrorContains(t, err, "third parties, maximum is 100")
+	})
 }
 
 func TestSellerToGOBL(t *testing.T) {
@@ -880,9 +1048,8 @@ func TestSellerToGOBL(t *testing.T) {
 			VATPrefix: "PL",
 			NIP:       "1234567890",
 			Name:      "Test Company",
-			Contact: &ksef.ContactDetails{
-				Phone: "+48 123 456 789",
-				Email: "contact@test.pl",
+			Contacts: []*ksef.ContactDetails{
+				{Phone: "+48 123 456 789", Email: "contact@test.pl"},
 			},
 		}
 
@@ -977,9 +1144,8 @@ func TestBuyerToGOBL(t *testing.T) {
 		buyer := &ksef.Buyer{
 			NIP:  "1234567890",
 			Name: "Test Buyer",
-			Contact: &ksef.ContactDetails{
-				Phone: "+48 987 654 321",
-				Email: "buyer@example.pl",
+			Contacts: []*ksef.ContactDetails{
+				{Phone: "+48 987 654 321", Email: "buyer@example.pl"},
 			},
 		}
 
@@ -1002,3 +1168,394 @@ func TestBuyerToGOBL(t *testing.T) {
 		assert.Empty(t, party.Addresses)
 	})
 }
+
+func TestNewAuthorizedEntity(t *testing.T) {
+	t.Run("returns nil without an issuer", func(t *testing.T) {
+		inv := &bill.Invoice{}
+
+		entity, err := ksef.NewAuthorizedEntity(inv)
+		require.NoError(t, err)
+		assert.Nil(t, entity)
+	})
+
+	t.Run("returns nil when issuer has no authorized role", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Ordering: &bill.Ordering{
+				Issuer: &org.Party{Name: "Issuer"},
+			},
+		}
+
+		entity, err := ksef.NewAuthorizedEntity(inv)
+		require.NoError(t, err)
+		assert.Nil(t, entity)
+	})
+
+	issuer := func() *org.Party {
+		return &org.Party{
+			Name: "Komornik Sądowy",
+			TaxID: &tax.Identity{
+				Country: l10n.PL.Tax(),
+				Code:    "7251234561",
+			},
+			Addresses: []*org.Address{
+				{Street: "ul. Sądowa 1", Code: "90-003", Locality: "Łódź", Country: "PL"},
+			},
+			Emails:     []*org.Email{{Address: "kancelaria@example.pl"}},
+			Telephones: []*org.Telephone{{Number: "+48 42 123 45 67"}},
+			Ext: tax.Extensions{
+				ksef.ExtKeyAuthorizedRole: "2",
+			},
+		}
+	}
+
+	t.Run("converts issuer with authorized role", func(t *testing.T) {
+		inv := &bill.Invoice{
+			Ordering: &bill.Ordering{Issuer: issuer()},
+		}
+
+		entity, err := ksef.NewAuthorizedEntity(inv)
+		require.NoError(t, err)
+
+		assert.Equal(t, &ksef.AuthorizedEntity{
+			NIP:  "7251234561",
+			Name: "Komornik Sądowy",
+			Address: &ksef.Address{
+				CountryCode: "PL",
+				AddressL1:   "ul. Sądowa 1",
+				AddressL2:   "90-003 Łódź",
+			},
+			Email: "kancelaria@example.pl",
+			Phone: "+48 42 123 45 67",
+			Role:  2,
+		}, entity)
+	})
+
+	tests := []struct {
+		name   string
+		modify func(*org.Party)
+		err    string
+	}{
+		{
+			name:   "invalid role",
+			modify: func(p *org.Party) { p.Ext[ksef.ExtKeyAuthorizedRole] = "x" },
+			err:    "authorized entity: invalid role 'x'",
+		},
+		{
+			name:   "missing NIP",
+			modify: func(p *org.Party) { p.TaxID = nil },
+			err:    "authorized entity: missing NIP",
+		},
+		{
+			name:   "missing name",
+			modify: func(p *org.Party) { p.Name = "" },
+			err:    "authorized entity: missing name",
+		},
+		{
+			name:   "missing address",
+			modify: func(p *org.Party) { p.Addresses = nil },
+			err:    "authorized entity: missing address",
+		},
+	}
+	for _, tt := range tests {
+		t.Run("rejects issuer with "+tt.name, func(t *testing.T) {
+			party := issuer()
+			tt.modify(party)
+			inv := &bill.Invoice{
+				Ordering: &bill.Ordering{Issuer: party},
+			}
+
+			entity, err := ksef.NewAuthorizedEntity(inv)
+			assert.EqualError(t, err, tt.err)
+			assert.Nil(t, entity)
+		})
+	}
+}
+
+func TestAuthorizedEntityToGOBL(t *testing.T) {
+	t.Run("converts authorized entity to GOBL party", func(t *testing.T) {
+		entity := &ksef.AuthorizedEntity{
+			NIP:  "7251234561",
+			Name: "Urząd Skarbowy",
+			Address: &ksef.Address{
+				CountryCode: "PL",
+				AddressL1:   "ul. Skarbowa 1",
+			},
+			Email: "us@example.pl",
+			Role:  1,
+		}
+
+		party := entity.ToGOBL()
+
+		assert.Equal(t, "Urząd Skarbowy", party.Name)
+		assert.Equal(t, l10n.PL.Tax(), party.TaxID.Country)
+		assert.Equal(t, "7251234561", party.TaxID.Code.String())
+		assert.Len(t, party.Addresses, 1)
+		assert.Len(t, party.Emails, 1)
+		assert.Empty(t, party.Telephones)
+		assert.Equal(t, "1", party.Ext.Get(ksef.ExtKeyAuthorizedRole).String())
+	})
+}
+
+func TestPartyDetails(t *testing.T) {
+	supplier := func() *org.Party {
+		return &org.Party{
+			Name:  "Transbud Sp. z o.o. w likwidacji",
+			TaxID: &tax.Identity{Country: l10n.PL.Tax(), Code: "9876543210"},
+			Identities: []*org.Identity{
+				{Type: ksef.IdentityTypeEORI, Code: "PL987654321000000"},
+				{Key: org.IdentityKeyGLN, Code: "5901234000015"},
+			},
+			Addresses: []*org.Address{
+				{Street: "ul. Składowa 14", Locality: "Bydgoszcz", Code: "85-001", Country: "PL"},
+				{Street: "ul. Kancelaryjna 2", Locality: "Toruń", Code: "87-100", Country: "PL"},
+			},
+			Ext: tax.Extensions{ksef.ExtKeyTaxpayerStatus: "1"},
+		}
+	}
+
+	t.Run("sets seller EORI, GLN, correspondence address and status", func(t *testing.T) {
+		seller := ksef.NewFavatSeller(supplier())
+
+		assert.Equal(t, "PL987654321000000", seller.EORI)
+		assert.Equal(t, "5901234000015", seller.Address.GLN)
+		require.NotNil(t, seller.CorrespondenceAddress)
+		assert.Equal(t, "ul. Kancelaryjna 2", seller.CorrespondenceAddress.AddressL1)
+		assert.Equal(t, "87-100 Toruń", seller.CorrespondenceAddress.AddressL2)
+		assert.Empty(t, seller.CorrespondenceAddress.GLN)
+		assert.Equal(t, 1, seller.TaxpayerStatus)
+	})
+
+	t.Run("sets buyer EORI and customer number", func(t *testing.T) {
+		customer := supplier()
+		customer.Identities = append(customer.Identities, &org.Identity{
+			Key:  ksef.IdentityKeyCustomerNumber,
+			Code: "K-00417",
+		})
+
+		buyer := ksef.NewFavatBuyer(customer)
+
+		assert.Equal(t, "PL987654321000000", buyer.EORI)
+		assert.Equal(t, "K-00417", buyer.CustomerNumber)
+		require.NotNil(t, buyer.CorrespondenceAddress)
+	})
+
+	t.Run("parses seller details", func(t *testing.T) {
+		party := ksef.NewFavatSeller(supplier()).ToGOBL()
+
+		require.Len(t, party.Identities, 2)
+		assert.Equal(t, ksef.IdentityTypeEORI, party.Identities[0].Type)
+		assert.Equal(t, org.IdentityKeyGLN, party.Identities[1].Key)
+		assert.Equal(t, cbc.Code("5901234000015"), party.Identities[1].Code)
+		require.Len(t, party.Addresses, 2)
+		assert.Equal(t, "ul. Kancelaryjna", party.Addresses[1].Street)
+		assert.Equal(t, "2", party.Addresses[1].Number)
+		assert.Equal(t, cbc.Code("87-100"), party.Addresses[1].Code)
+		assert.Equal(t, "Toruń", party.Addresses[1].Locality)
+		assert.Equal(t, cbc.Code("1"), party.Ext.Get(ksef.ExtKeyTaxpayerStatus))
+	})
+
+	t.Run("parses buyer details", func(t *testing.T) {
+		buyer := &ksef.Buyer{
+			EORI:           "PL111111111100000",
+			NIP:            "1111111111",
+			Name:           "Import-Eksport Bałtyk S.A.",
+			CustomerNumber: "K-00417",
+		}
+
+		party := buyer.ToGOBL()
+
+		require.Len(t, party.Identities, 2)
+		assert.Equal(t, cbc.Code("PL111111111100000"), party.Identities[0].Code)
+		assert.Equal(t, ksef.IdentityKeyCustomerNumber, party.Identities[1].Key)
+		assert.Equal(t, cbc.Code("K-00417"), party.Identities[1].Code)
+	})
+
+	t.Run("keeps customer numbers that are not valid codes in the meta", func(t *testing.T) {
+		buyer := &ksef.Buyer{
+			NIP:            "1111111111",
+			Name:           "Import-Eksport Bałtyk S.A.",
+			CustomerNumber: "K 00417 (hurt)",
+		}
+
+		party := buyer.ToGOBL()
+
+		assert.Nil(t, org.IdentityForKey(party.Identities, ksef.IdentityKeyCustomerNumber))
+		assert.Equal(t, "K 00417 (hurt)", party.Meta[ksef.MetaKeyCustomerNumber])
+		assert.Equal(t, "K 00417 (hurt)", ksef.NewFavatBuyer(party).CustomerNumber)
+	})
+}
+
+func TestContactDetails(t *testing.T) {
+	party := func() *org.Party {
+		return &org.Party{
+			Name:  "Test Company Sp. z o.o.",
+			TaxID: &tax.Identity{Country: l10n.PL.Tax(), Code: "1234567890"},
+		}
+	}
+
+	t.Run("pairs emails and phones with the same label", func(t *testing.T) {
+		supplier := party()
+		supplier.Emails = []*org.Email{
+			{Label: "Billing", Address: "billing@example.pl"},
+			{Label: "Sales", Address: "anna@example.pl"},
+		}
+		supplier.Telephones = []*org.Telephone{
+			{Label: "Sales", Number: "+48 111 111 111"},
+			{Label: "Billing", Number: "+48 222 222 222"},
+		}
+
+		seller := ksef.NewFavatSeller(supplier)
+
+		assert.Equal(t, []*ksef.ContactDetails{
+			{Email: "billing@example.pl", Phone: "+48 222 222 222"},
+			{Email: "anna@example.pl", Phone: "+48 111 111 111"},
+		}, seller.Contacts)
+	})
+
+	t.Run("pairs unlabelled phones in order", func(t *testing.T) {
+		customer := party()
+		customer.Emails = []*org.Email{
+			{Address: "billing@example.pl"},
+		}
+		customer.Telephones = []*org.Telephone{
+			{Number: "+48 111 111 111"},
+			{Number: "+48 222 222 222"},
+		}
+
+		buyer := ksef.NewFavatBuyer(customer)
+
+		assert.Equal(t, []*ksef.ContactDetails{
+			{Email: "billing@example.pl", Phone: "+48 111 111 111"},
+			{Phone: "+48 222 222 222"},
+		}, buyer.Contacts)
+	})
+
+	t.Run("emits up to three contact details", func(t *testing.T) {
+		supplier := party()
+		for _, e := range []string{"a@example.pl", "b@example.pl", "c@example.pl", "d@example.pl"} {
+			supplier.Emails = append(supplier.Emails, &org.Email{Address: e})
+		}
+		supplier.Telephones = []*org.Telephone{
+			{Number: "+48 111 111 111"},
+		}
+
+		seller := ksef.NewFavatSeller(supplier)
+
+		require.Len(t, seller.Contacts, 3)
+		assert.Equal(t, "c@example.pl", seller.Contacts[2].Email)
+		assert.Equal(t, "+48 111 111 111", seller.Contacts[0].Phone)
+	})
+
+	t.Run("parses all contact details", func(t *testing.T) {
+		buyer := &ksef.Buyer{
+			NIP:  "1234567890",
+			Name: "Test Buyer",
+			Contacts: []*ksef.ContactDetails{
+				{Email: "billing@example.pl", Phone: "+48 111 111 111"},
+				{Email: "jan@example.pl"},
+				{Phone: "+48 222 222 222"},
+			},
+		}
+
+		p := buyer.ToGOBL()
+
+		require.Len(t, p.Emails, 2)
+		assert.Equal(t, "billing@example.pl", p.Emails[0].Address)
+		assert.Equal(t, "jan@example.pl", p.Emails[1].Address)
+		require.Len(t, p.Telephones, 2)
+		assert.Equal(t, "+48 111 111 111", p.Telephones[0].Number)
+		assert.Equal(t, "+48 222 222 222", p.Telephones[1].Number)
+	})
+}
+
+func TestParseThirdParties(t *testing.T) {
+	load := func(t *testing.T) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", "invoice-third-parties.xml"))
+		require.NoError(t, err)
+		return string(data)
+	}
+	parse := func(t *testing.T, data string) (*bill.Invoice, []string) {
+		t.Helper()
+		var warnings []string
+		env, err := ksef.ParseKSeF([]byte(data), ksef.WithWarningHandler(func(w string) {
+			warnings = append(warnings, w)
+		}))
+		require.NoError(t, err)
+		return env.Extract().(*bill.Invoice), warnings
+	}
+
+	t.Run("maps each role to its invoice party", func(t *testing.T) {
+		inv, warnings := parse(t, load(t))
+
+		assert.Empty(t, warnings)
+		require.NotNil(t, inv.Payment.Payee)
+		assert.Equal(t, "Faktoring Polska S.A.", inv.Payment.Payee.Name)
+		require.NotNil(t, inv.Delivery.Receiver)
+		assert.Equal(t, cbc.Code("1111111111-00002"), org.IdentityForKey(inv.Delivery.Receiver.Identities, ksef.IdentityKeyInternalID).Code)
+		require.NotNil(t, inv.Ordering.Issuer)
+		assert.Equal(t, "Biuro Rachunkowe Bilans s.c.", inv.Ordering.Issuer.Name)
+		assert.Empty(t, inv.Ordering.Issuer.Ext)
+	})
+
+	t.Run("keeps other roles and additional buyers in the ordering parties", func(t *testing.T) {
+		inv, _ := parse(t, load(t))
+
+		require.NotNil(t, inv.Ordering.Seller)
+		assert.Equal(t, "Agent rozliczeniowy", inv.Ordering.Seller.Label)
+		assert.Equal(t, cbc.Code("1"), inv.Ordering.Seller.Ext.Get(ksef.ExtKeyOtherRole))
+		require.NotNil(t, inv.Ordering.Buyer)
+		assert.Equal(t, cbc.Code("4"), inv.Ordering.Buyer.Ext.Get(favat.ExtKeyThirdPartyRole))
+		assert.Equal(t, "30", inv.Ordering.Buyer.Meta[ksef.MetaKeyShare])
+	})
+
+	t.Run("keeps third parties without a free party as customer identities", func(t *testing.T) {
+		extra := `<Podmiot3>
+    <DaneIdentyfikacyjne>
+      <NIP>5252248481</NIP>
+      <Nazwa>Drugi Faktor S.A.</Nazwa>
+    </DaneIdentyfikacyjne>
+    <Rola>1</Rola>
+  </Podmiot3>
+  <Fa>`
+		inv, warnings := parse(t, strings.Replace(load(t), "<Fa>", extra, 1))
+
+		require.Len(t, warnings, 1)
+		assert.Equal(t, "Podmiot3: third party 'Drugi Faktor S.A.' kept as a customer identity", warnings[0])
+		id := org.IdentityForExtKey(inv.Customer.Identities, favat.ExtKeyThirdPartyRole)
+		require.NotNil(t, id)
+		assert.Equal(t, cbc.Code("5252248481"), id.Code)
+		assert.Equal(t, "Drugi Faktor S.A.", id.Description)
+
+		thirdParties := ksef.NewThirdParties(inv)
+		require.NotEmpty(t, thirdParties)
+		last := thirdParties[len(thirdParties)-1]
+		assert.Equal(t, "Drugi Faktor S.A.", last.Name)
+		assert.Equal(t, "1", last.Role)
+		assert.Equal(t, "5252248481", last.NIP)
+	})
+
+	t.Run("keeps every JST and VAT group member identity", func(t *testing.T) {
+		extra := `<Podmiot3>
+    <DaneIdentyfikacyjne>
+      <NIP>5252248481</NIP>
+    </DaneIdentyfikacyjne>
+    <Rola>8</Rola>
+  </Podmiot3>
+  <Podmiot3>
+    <DaneIdentyfikacyjne>
+      <NIP>7740001454</NIP>
+    </DaneIdentyfikacyjne>
+    <Rola>10</Rola>
+  </Podmiot3>
+  <Fa>`
+		inv, _ := parse(t, strings.Replace(load(t), "<Fa>", extra, 1))
+
+		var roles []string
+		for _, tp := range ksef.NewThirdParties(inv) {
+			roles = append(roles, tp.Role+":"+tp.NIP)
+		}
+		assert.Contains(t, roles, "8:5252248481")
+		assert.Contains(t, roles, "10:7740001454")
+	})
+}
@@ -158,7 +158,11 @@ func (inv *Inv) parsePayment(goblInv *bill.Invoice) error {
 		return nil
 	}
 
-	payment := &bill.PaymentDetails{}
+	// Keep the payee parsed from the third parties
+	payment := goblInv.Payment
+	if payment == nil {
+		payment = new(bill.PaymentDetails)
+	}
 
 	// Parse payment instructions
 	if inv.Payment.PaymentMean != "" || len(inv.Payment.BankAccounts) > 0 {
@@ -50,11 +50,17 @@ func AdjustRounding(inv *bill.Invoice, ksefTotalDue string) error {
 		return nil
 	}
 
+	// Add the difference to any rounding already applied
+	rounding := diff
+	if inv.Totals.Rounding != nil {
+		rounding = inv.Totals.Rounding.Add(diff)
+	}
+
 	// Check if the difference can be attributed to rounding
 	maxErr := MaxRoundingError(inv)
 	if diff.Abs().Compare(maxErr) == 1 {
 		// Too much difference. Apply the adjustment anyway and return a warning
-		inv.Totals.Rounding = &diff
+		inv.Totals.Rounding = &rounding
 		return &RoundingError{
 			Invoice:    inv,
 			Diff:       diff,
@@ -63,11 +69,48 @@ func AdjustRounding(inv *bill.Invoice, ksefTotalDue string) error {
 	}
 
 	// Apply the rounding adjustment
-	inv.Totals.Rounding = &diff
+	inv.Totals.Rounding = &rounding
+	if rounding.IsZero() {
+		inv.Totals.Rounding = nil
+	}
 
 	return nil
 }
 
+// AdjustLineRounding adjusts the rounding in the GOBL invoice to the VAT
+// amounts rounded on each line (P_11Vat), which add up to lineVAT, before
+// checking the KSEF total amount with AdjustRounding. The VAT of taxed
+// charges and discounts is not reported per line, so the line amounts are
+// not used in invoices that have them.
+func AdjustLineRounding(inv *bill.Invoice, ksefTotalDue string, lineVAT num.Amount) error {
+	if err := inv.Calculate(); err != nil {
+		return err
+	}
+
+	if !hasTaxedChargesOrDiscounts(inv) {
+		diff := lineVAT.Subtract(inv.Totals.Tax)
+		if !diff.IsZero() {
+			inv.Totals.Rounding = &diff
+		}
+	}
+
+	return AdjustRounding(inv, ksefTotalDue)
+}
+
+func hasTaxedChargesOrDiscounts(inv *bill.Invoice) bool {
+	for _, c := range inv.Charges {
+		if len(c.Taxes) > 0 {
+			return true
+		}
+	}
+	for _, d := range inv.Discounts {
+		if len(d.Taxes) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // MaxRoundingError returns the maximum error that can be attributed to rounding in an invoice.
 // It calculates 0.75 of the smallest subunit of the currency per line.
 func MaxRoundingError(inv *bill.Invoice) num.Amount {
@@ -435,3 +435,62 @@ func TestAdjustRounding(t *testing.T) {
 		assert.True(t, inv.Totals.Rounding.Abs().Compare(num.MakeAmount(2, 2)) <= 0)
 	})
 }
+
+func TestAdjustLineRounding(t *testing.T) {
+	lineInvoice := func() *bill.Invoice {
+		inv := &bill.Invoice{
+			Currency: currency.PLN,
+			Supplier: &org.Party{
+				TaxID: &tax.Identity{Country: l10n.PL.Tax(), Code: "1234567890"},
+			},
+			Customer: &org.Party{
+				TaxID: &tax.Identity{Country: l10n.PL.Tax(), Code: "9876543210"},
+			},
+			Tax: &bill.Tax{
+				Ext: tax.Extensions{favat.ExtKeyInvoiceType: "VAT"},
+			},
+		}
+		for i := 1; i <= 3; i++ {
+			price := num.MakeAmount(115, 2)
+			inv.Lines = append(inv.Lines, &bill.Line{
+				Index:    i,
+				Quantity: num.MakeAmount(1, 0),
+				Item:     &org.Item{Name: "Item", Price: &price},
+				Taxes:    tax.Set{&tax.Combo{Category: tax.CategoryVAT, Percent: num.NewPercentage(23, 2)}},
+			})
+		}
+		return inv
+	}
+
+	t.Run("applies the rounding of the line VAT amounts", func(t *testing.T) {
+		inv := lineInvoice()
+
+		// 3 x 0.26 instead of 0.79 for the rate
+		err := ksef.AdjustLineRounding(inv, "4.23", num.MakeAmount(78, 2))
+		require.NoError(t, err)
+
+		require.NotNil(t, inv.Totals.Rounding)
+		assert.Equal(t, "-0.01", inv.Totals.Rounding.String())
+		assert.Equal(t, "4.23", inv.Totals.Payable.String())
+	})
+
+	t.Run("adds the remaining difference to the line rounding", func(t *testing.T) {
+		inv := lineInvoice()
+
+		err := ksef.AdjustLineRounding(inv, "4.24", num.MakeAmount(78, 2))
+		require.NoError(t, err)
+
+		assert.Nil(t, inv.Totals.Rounding)
+	})
+
+	t.Run("rejects totals that do not match the line amounts", func(t *testing.T) {
+		inv := lineInvoice()
+
+		err := ksef.AdjustLineRounding(inv, "4.30", num.MakeAmount(78, 2))
+
+		var re *ksef.RoundingError
+		require.ErrorAs(t, err, &re)
+		assert.Equal(t, "0.07", re.Diff.String())
+		assert.Equal(t, "0.06", inv.Totals.Rounding.String())
+	})
+}
@@ -0,0 +1,220 @@
+package ksef
+
+import (
+	"fmt"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/tax"
+)
+
+// Settlement defines the XML structure for additional charges and deductions (Rozliczenie)
+type Settlement struct {
+	Charges         []*ChargeOrDeduction `xml:"Obciazenia,omitempty"` // up to 100
+	TotalCharges    string               `xml:"SumaObciazen,omitempty"`
+	Deductions      []*ChargeOrDeduction `xml:"Odliczenia,omitempty"` // up to 100
+	TotalDeductions string               `xml:"SumaOdliczen,omitempty"`
+	AmountToPay     string               `xml:"DoZaplaty,omitempty"`
+	AmountToSettle  string               `xml:"DoRozliczenia,omitempty"`
+}
+
+// ChargeOrDeduction defines the XML structure for a single charge or deduction
+type ChargeOrDeduction struct {
+	Amount string `xml:"Kwota"`
+	Reason string `xml:"Powod"`
+}
+
+// maxSettlementEntries is the maximum number of charges, and of deductions,
+// allowed by the schema
+const maxSettlementEntries = 100
+
+// validateSettlement checks that the charges and discounts not subject to VAT
+// can be reported in the settlement block, which requires a reason for each
+// of them and accepts up to 100 of each kind.
+func validateSettlement(inv *bill.Invoice) error {
+	charges := untaxedCharges(inv.Charges)
+	if len(charges) > maxSettlementEntries {
+		return fmt.Errorf("%d charges without VAT, maximum is %d", len(charges), maxSettlementEntries)
+	}
+	for _, c := range charges {
+		if chargeReason(c.Reason, c.Key.String()) == "" {
+			return fmt.Errorf("charge %d without VAT: missing reason or key", c.Index)
+		}
+	}
+	discounts := untaxedDiscounts(inv.Discounts)
+	if len(discounts) > maxSettlementEntries {
+		return fmt.Errorf("%d discounts without VAT, maximum is %d", len(discounts), maxSettlementEntries)
+	}
+	for _, d := range discounts {
+		if chargeReason(d.Reason, d.Key.String()) == "" {
+			return fmt.Errorf("discount %d without VAT: missing reason or key", d.Index)
+		}
+	}
+	return nil
+}
+
+// NewSettlement builds the settlement block from the document level charges and
+// discounts that are not subject to VAT. Such amounts are not part of the total
+// reported in P_15, so KSeF expects them to be listed separately, together with
+// the final amount to pay. The payable amount provided must already include them,
+// as calculated by GOBL. Returns nil when there is nothing to settle.
+func NewSettlement(charges []*bill.Charge, discounts []*bill.Discount, payable num.Amount) *Settlement {
+	s := new(Settlement)
+
+	for _, c := range untaxedCharges(charges) {
+		s.Charges = append(s.Charges, &ChargeOrDeduction{
+			Amount: c.Amount.String(),
+			Reason: chargeReason(c.Reason, c.Key.String()),
+		})
+	}
+	for _, d := range untaxedDiscounts(discounts) {
+		s.Deductions = append(s.Deductions, &ChargeOrDeduction{
+			Amount: d.Amount.String(),
+			Reason: chargeReason(d.Reason, d.Key.String()),
+		})
+	}
+
+	if len(s.Charges) == 0 && len(s.Deductions) == 0 {
+		return nil
+	}
+
+	totalCharges, totalDeductions := settlementTotals(charges, discounts, payable.Exp())
+	if len(s.Charges) > 0 {
+		s.TotalCharges = totalCharges.String()
+	}
+	if len(s.Deductions) > 0 {
+		s.TotalDeductions = totalDeductions.String()
+	}
+
+	// DoZaplaty and DoRozliczenia are mutually exclusive, the latter being used
+	// when the deductions exceed the amount due.
+	if payable.IsNegative() {
+		s.AmountToSettle = payable.Negate().String()
+	} else {
+		s.AmountToPay = payable.String()
+	}
+
+	return s
+}
+
+// settlementTotals sums the untaxed charges and discounts that end up in the
+// settlement block.
+func settlementTotals(charges []*bill.Charge, discounts []*bill.Discount, exp uint32) (num.Amount, num.Amount) {
+	totalCharges := num.MakeAmount(0, exp)
+	for _, c := range untaxedCharges(charges) {
+		totalCharges = totalCharges.MatchPrecision(c.Amount).Add(c.Amount)
+	}
+	totalDeductions := num.MakeAmount(0, exp)
+	for _, d := range untaxedDiscounts(discounts) {
+		totalDeductions = totalDeductions.MatchPrecision(d.Amount).Add(d.Amount)
+	}
+	return totalCharges, totalDeductions
+}
+
+func untaxedCharges(charges []*bill.Charge) []*bill.Charge {
+	var list []*bill.Charge
+	for _, c := range charges {
+		if c.Taxes.Get(tax.CategoryVAT) == nil {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+func untaxedDiscounts(discounts []*bill.Discount) []*bill.Discount {
+	var list []*bill.Discount
+	for _, d := range discounts {
+		if d.Taxes.Get(tax.CategoryVAT) == nil {
+			list = append(list, d)
+		}
+	}
+	return list
+}
+
+// balance returns the amount the settlement adds to the P_15 total, which is
+// the sum of the charges minus the sum of the deductions. The individual entries
+// are summed when the optional totals are missing.
+func (s *Settlement) balance() (num.Amount, error) {
+	charges, err := settlementSum(s.TotalCharges, s.Charges)
+	if err != nil {
+		return num.Amount{}, fmt.Errorf("parsing charges: %w", err)
+	}
+	deductions, err := settlementSum(s.TotalDeductions, s.Deductions)
+	if err != nil {
+		return num.Amount{}, fmt.Errorf("parsing deductions: %w", err)
+	}
+	return charges.MatchPrecision(deductions).Subtract(deductions), nil
+}
+
+func settlementSum(total string, entries []*ChargeOrDeduction) (num.Amount, error) {
+	if total != "" {
+		return parseAmount(total)
+	}
+	sum := num.MakeAmount(0, 2)
+	for _, e := range entries {
+		amount, err := parseAmount(e.Amount)
+		if err != nil {
+			return sum, err
+		}
+		sum = sum.MatchPrecision(amount).Add(amount)
+	}
+	return sum, nil
+}
+
+func chargeReason(reason, key string) string {
+	if reason != "" {
+		return reason
+	}
+	return key
+}
+
+// parseSettlement converts the KSeF settlement block into GOBL document level
+// charges and discounts without taxes.
+func (inv *Inv) parseSettlement(goblInv *bill.Invoice) error {
+	if inv.Settlement == nil {
+		return nil
+	}
+
+	for _, c := range inv.Settlement.Charges {
+		amount, err := parseAmount(c.Amount)
+		if err != nil {
+			return fmt.Errorf("parsing charge amount: %w", err)
+		}
+		goblInv.Charges = append(goblInv.Charges, &bill.Charge{
+			Reason: c.Reason,
+			Amount: amount,
+		})
+	}
+
+	for _, d := range inv.Settlement.Deductions {
+		amount, err := parseAmount(d.Amount)
+		if err != nil {
+			return fmt.Errorf("parsing deduction amount: %w", err)
+		}
+		goblInv.Discounts = append(goblInv.Discounts, &bill.Discount{
+			Reason: d.Reason,
+			Amount: amount,
+		})
+	}
+
+	return nil
+}
+
+// amountToPay returns the final amount to pay, which is P_15 adjusted by the
+// settlement charges and deductions, if any.
+func (inv *Inv) amountToPay() (string, error) {
+	if inv.Settlement == nil {
+		return inv.TotalAmountDue, nil
+	}
+
+	total, err := num.AmountFromString(inv.TotalAmountDue)
+	if err != nil {
+		return "", fmt.Errorf("parsing KSEF total amount: %w", err)
+	}
+	balance, err := inv.Settlement.balance()
+	if err != nil {
+		return "", err
+	}
+
+	return total.MatchPrecision(balance).Add(balance).String(), nil
+}
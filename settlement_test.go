@@ -0,0 +1,149 @@
+package ksef_test
+
+import (
+	"testing"
+
+	"github.com/invopop/gobl"
+	ksef "github.com/invopop/gobl.ksef"
+	"github.com/invopop/gobl.ksef/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/num"
+	"github.com/invopop/gobl/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSettlement(t *testing.T) {
+	t.Run("should return nil when there are no charges or discounts", func(t *testing.T) {
+		s := ksef.NewSettlement(nil, nil, num.MakeAmount(10000, 2))
+		assert.Nil(t, s)
+	})
+
+	t.Run("should skip charges and discounts subject to VAT", func(t *testing.T) {
+		charges := []*bill.Charge{
+			{
+				Amount: num.MakeAmount(1000, 2),
+				Taxes:  tax.Set{{Category: tax.CategoryVAT, Rate: tax.RateGeneral}},
+			},
+		}
+		discounts := []*bill.Discount{
+			{
+				Amount: num.MakeAmount(500, 2),
+				Taxes:  tax.Set{{Category: tax.CategoryVAT, Rate: tax.RateGeneral}},
+			},
+		}
+
+		s := ksef.NewSettlement(charges, discounts, num.MakeAmount(10000, 2))
+		assert.Nil(t, s)
+	})
+
+	t.Run("should map untaxed charges and discounts", func(t *testing.T) {
+		charges := []*bill.Charge{
+			{Amount: num.MakeAmount(6000, 2), Reason: "Kaucja"},
+			{Amount: num.MakeAmount(1000, 2), Key: cbc.Key("handling")},
+		}
+		discounts := []*bill.Discount{
+			{Amount: num.MakeAmount(1200, 2), Reason: "Zwrot opakowań"},
+		}
+
+		s := ksef.NewSettlement(charges, discounts, num.MakeAmount(58980, 2))
+		require.NotNil(t, s)
+
+		assert.Equal(t, []*ksef.ChargeOrDeduction{
+			{Amount: "60.00", Reason: "Kaucja"},
+			{Amount: "10.00", Reason: "handling"},
+		}, s.Charges)
+		assert.Equal(t, "70.00", s.TotalCharges)
+		assert.Equal(t, []*ksef.ChargeOrDeduction{
+			{Amount: "12.00", Reason: "Zwrot opakowań"},
+		}, s.Deductions)
+		assert.Equal(t, "12.00", s.TotalDeductions)
+		assert.Equal(t, "589.80", s.AmountToPay)
+		assert.Empty(t, s.AmountToSettle)
+	})
+
+	t.Run("should report amount to settle when payable is negative", func(t *testing.T) {
+		discounts := []*bill.Discount{
+			{Amount: num.MakeAmount(20000, 2), Reason: "Zwrot kaucji"},
+		}
+
+		s := ksef.NewSettlement(nil, discounts, num.MakeAmount(-5000, 2))
+		require.NotNil(t, s)
+
+		assert.Empty(t, s.TotalCharges)
+		assert.Equal(t, "200.00", s.TotalDeductions)
+		assert.Empty(t, s.AmountToPay)
+		assert.Equal(t, "50.00", s.AmountToSettle)
+	})
+}
+
+func TestSettlementConversion(t *testing.T) {
+	t.Run("should exclude settlement from P_15", func(t *testing.T) {
+		doc, err := test.BuildFAVATFrom("invoice-charges.json")
+		require.NoError(t, err)
+
+		assert.Equal(t, "541.80", doc.Inv.TotalAmountDue)
+		require.NotNil(t, doc.Inv.Settlement)
+		assert.Equal(t, "589.80", doc.Inv.Settlement.AmountToPay)
+	})
+
+	t.Run("should parse settlement into charges and discounts", func(t *testing.T) {
+		data, err := test.LoadOutputFile("invoice-charges.xml")
+		require.NoError(t, err)
+
+		env, err := ksef.ParseKSeF(data)
+		require.NoError(t, err)
+		goblInv, ok := env.Extract().(*bill.Invoice)
+		require.True(t, ok)
+
+		require.Len(t, goblInv.Charges, 1)
+		assert.Equal(t, "Kaucja za opakowania zwrotne", goblInv.Charges[0].Reason)
+		assert.Equal(t, "60.00", goblInv.Charges[0].Amount.String())
+		require.Len(t, goblInv.Discounts, 1)
+		assert.Equal(t, "Zwrot opakowań zwrotnych", goblInv.Discounts[0].Reason)
+		assert.Equal(t, "12.00", goblInv.Discounts[0].Amount.String())
+
+		require.NoError(t, goblInv.Calculate())
+		assert.Equal(t, "589.80", goblInv.Totals.Payable.String())
+	})
+}
+
+func TestValidateSettlement(t *testing.T) {
+	load := func(t *testing.T) (*gobl.Envelope, *bill.Invoice) {
+		t.Helper()
+		env, err := test.LoadTestEnvelope("invoice-charges.json")
+		require.NoError(t, err)
+		return env, env.Extract().(*bill.Invoice)
+	}
+
+	t.Run("rejects charges without reason or key", func(t *testing.T) {
+		env, inv := load(t)
+		for _, c := range untaxed(inv.Charges) {
+			c.Reason, c.Key = "", ""
+		}
+
+		_, err := ksef.BuildFavat(env)
+		assert.ErrorContains(t, err, "without VAT: missing reason or key")
+	})
+
+	t.Run("rejects more than 100 charges", func(t *testing.T) {
+		env, inv := load(t)
+		for i := 0; i < 100; i++ {
+			inv.Charges = append(inv.Charges, &bill.Charge{Amount: num.MakeAmount(100, 2), Reason: "Kaucja"})
+		}
+
+		_, err := ksef.BuildFavat(env)
+		assert.ErrorContains(t, err, "charges without VAT, maximum is 100")
+	})
+}
+
+func untaxed(charges []*bill.Charge) []*bill.Charge {
+	var list []*bill.Charge
+	for _, c := range charges {
+		if c.Taxes.Get(tax.CategoryVAT) == nil {
+			list = append(list, c)
+		}
+	}
+	return list
+}
@@ -0,0 +1,358 @@
+package ksef
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/org"
+)
+
+// Keys used to store transport details in the GOBL delivery details.
+const (
+	// MetaKeyTransportType holds the transport type, one of the keys in
+	// transportTypes, or a free text description for other types.
+	MetaKeyTransportType cbc.Key = "transport-type"
+	// MetaKeyCargoType holds the cargo type, one of the keys in cargoTypes,
+	// or a free text description for other types.
+	MetaKeyCargoType cbc.Key = "cargo-type"
+	// MetaKeyPackagingUnit holds the packaging unit description.
+	MetaKeyPackagingUnit cbc.Key = "packaging-unit"
+	// MetaKeyTransportStart holds the date and time the transport starts,
+	// like 2026-02-13T08:00:00 or 2026-02-13T08:00:00+01:00.
+	MetaKeyTransportStart cbc.Key = "transport-start"
+	// MetaKeyTransportEnd holds the date and time the transport ends.
+	MetaKeyTransportEnd cbc.Key = "transport-end"
+	// IdentityKeyTransportOrder identifies the transport order number.
+	IdentityKeyTransportOrder cbc.Key = "transport-order"
+	// IdentityKeyCarrier identifies the carrier, with its tax code in the identity
+	// code, its name in the label, and its address in the description.
+	IdentityKeyCarrier cbc.Key = "carrier"
+)
+
+// maxTransportDesc is the maximum number of characters of the other
+// transport and cargo type descriptions (TZnakowy50)
+const maxTransportDesc = 50
+
+// transportTypes maps the transport type keys to the KSeF codes (TRodzajTransportu)
+var transportTypes = map[string]string{
+	"sea":                "1",
+	"rail":               "2",
+	"road":               "3",
+	"air":                "4",
+	"post":               "5",
+	"fixed-installation": "7",
+	"inland-waterway":    "8",
+}
+
+// cargoTypes maps the cargo type keys to the KSeF codes (TLadunek)
+var cargoTypes = map[string]string{
+	"churn":                 "1",
+	"barrel":                "2",
+	"cylinder":              "3",
+	"carton":                "4",
+	"canister":              "5",
+	"cage":                  "6",
+	"container":             "7",
+	"basket":                "8",
+	"punnet":                "9",
+	"multipack":             "10",
+	"parcel":                "11",
+	"packet":                "12",
+	"pallet":                "13",
+	"receptacle":            "14",
+	"solid-bulk-container":  "15",
+	"liquid-bulk-container": "16",
+	"box":                   "17",
+	"can":                   "18",
+	"crate":                 "19",
+	"sack":                  "20",
+}
+
+// Transport defines the XML structure for transport information
+type Transport struct {
+	TransportType        string     `xml:"RodzajTransportu,omitempty"`
+	OtherTransportType   int        `xml:"TransportInny,omitempty"`
+	OtherTransportDesc   string     `xml:"OpisInnegoTransportu,omitempty"`
+	Carrier              *Carrier   `xml:"Przewoznik,omitempty"`
+	TransportOrderNumber string     `xml:"NrZleceniaTransportu,omitempty"`
+	CargoType            string     `xml:"OpisLadunku,omitempty"`
+	OtherCargoType       int        `xml:"LadunekInny,omitempty"`
+	OtherCargoDesc       string     `xml:"OpisInnegoLadunku,omitempty"`
+	PackagingUnit        string     `xml:"JednostkaOpakowania,omitempty"`
+	TransportStartTime   string     `xml:"DataGodzRozpTransportu,omitempty"`
+	TransportEndTime     string     `xml:"DataGodzZakTransportu,omitempty"`
+	ShipFrom             *Address   `xml:"WysylkaZ,omitempty"`
+	ShipVia              []*Address `xml:"WysylkaPrzez,omitempty"`
+	ShipTo               *Address   `xml:"WysylkaDo,omitempty"`
+}
+
+// Carrier defines the XML structure for carrier information
+type Carrier struct {
+	NIP string `xml:"DaneIdentyfikacyjne>NIP,omitempty"`
+	// or
+	UECode      string `xml:"DaneIdentyfikacyjne>KodUE,omitempty"`
+	UEVatNumber string `xml:"DaneIdentyfikacyjne>NrVatUE,omitempty"`
+	// or
+	CountryCode string `xml:"DaneIdentyfikacyjne>KodKraju,omitempty"`
+	IDNumber    string `xml:"DaneIdentyfikacyjne>NrID,omitempty"`
+	// or
+	NoID int `xml:"DaneIdentyfikacyjne>BrakID,omitempty"`
+
+	Name    string   `xml:"DaneIdentyfikacyjne>Nazwa,omitempty"`
+	Address *Address `xml:"AdresPrzewoznika"`
+}
+
+// NewTransport builds a transport entry from the invoice delivery details. The
+// transport and cargo types are taken from the delivery meta, and are both
+// required by KSeF, so nil is returned when either of them is missing. The
+// transport start and end times are only set from the delivery meta, as the
+// delivery period has no times.
+func NewTransport(delivery *bill.DeliveryDetails) *Transport {
+	if delivery == nil || delivery.Meta == nil {
+		return nil
+	}
+	meta := *delivery.Meta
+	if meta[MetaKeyTransportType] == "" || meta[MetaKeyCargoType] == "" {
+		return nil
+	}
+
+	t := &Transport{
+		PackagingUnit: meta[MetaKeyPackagingUnit],
+	}
+
+	if code, ok := transportTypes[meta[MetaKeyTransportType]]; ok {
+		t.TransportType = code
+	} else {
+		t.OtherTransportType = 1
+		t.OtherTransportDesc = truncate(meta[MetaKeyTransportType], maxTransportDesc)
+	}
+
+	if code, ok := cargoTypes[meta[MetaKeyCargoType]]; ok {
+		t.CargoType = code
+	} else {
+		t.OtherCargoType = 1
+		t.OtherCargoDesc = truncate(meta[MetaKeyCargoType], maxTransportDesc)
+	}
+
+	for _, id := range delivery.Identities {
+		switch id.Key {
+		case IdentityKeyTransportOrder:
+			t.TransportOrderNumber = id.Code.String()
+		case IdentityKeyCarrier:
+			t.Carrier = newCarrier(id)
+		}
+	}
+
+	t.TransportStartTime = meta[MetaKeyTransportStart]
+	t.TransportEndTime = meta[MetaKeyTransportEnd]
+
+	if delivery.Receiver != nil && len(delivery.Receiver.Addresses) > 0 {
+		t.ShipTo = newAddress(delivery.Receiver.Addresses[0])
+	}
+
+	return t
+}
+
+// validateTransport checks that the transport data of the delivery can be
+// reported: the carrier needs an address, and the transport times must be
+// dates and times.
+func validateTransport(inv *bill.Invoice) error {
+	delivery := inv.Delivery
+	if delivery == nil {
+		return nil
+	}
+	for _, id := range delivery.Identities {
+		if id.Key == IdentityKeyCarrier && id.Description == "" {
+			return fmt.Errorf("delivery carrier '%s': missing address in description", id.Code)
+		}
+	}
+	if delivery.Meta != nil {
+		for _, key := range []cbc.Key{MetaKeyTransportStart, MetaKeyTransportEnd} {
+			if value := (*delivery.Meta)[key]; value != "" {
+				if _, err := parseDateTime(value); err != nil {
+					return fmt.Errorf("delivery meta %s: %w", key, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func newCarrier(identity *org.Identity) *Carrier {
+	country := identity.Country
+	if country == "" {
+		country = l10n.PL.ISO()
+	}
+
+	carrier := &Carrier{
+		Name: identity.Label,
+		Address: &Address{
+			CountryCode: country.String(),
+			AddressL1:   identity.Description,
+		},
+	}
+
+	if country == l10n.PL.ISO() {
+		carrier.NIP = identity.Code.String()
+	} else if l10n.Union(l10n.EU).HasMember(country.Code()) {
+		carrier.UECode = country.String()
+		carrier.UEVatNumber = identity.Code.String()
+	} else {
+		carrier.CountryCode = country.String()
+		carrier.IDNumber = identity.Code.String()
+	}
+
+	return carrier
+}
+
+// parseTransport converts the first KSeF transport entry into GOBL delivery
+// details, as GOBL has a single delivery. Further entries are reported in the
+// warnings. The ship-to address is only used when the delivery receiver does
+// not already have one.
+func (d *Invoice) parseTransport(goblInv *bill.Invoice) error {
+	tc := d.Inv.TransactionConditions
+	if tc == nil || len(tc.Transport) == 0 {
+		return nil
+	}
+	t := tc.Transport[0]
+	if n := len(tc.Transport); n > 1 {
+		d.Warnings = append(d.Warnings, fmt.Sprintf("Fa>WarunkiTransakcji>Transport: only the first of %d transport entries was kept", n))
+	}
+
+	if goblInv.Delivery == nil {
+		goblInv.Delivery = new(bill.DeliveryDetails)
+	}
+	delivery := goblInv.Delivery
+	if delivery.Meta == nil {
+		delivery.Meta = &cbc.Meta{}
+	}
+	meta := *delivery.Meta
+
+	if t.TransportType != "" {
+		meta[MetaKeyTransportType] = lookupKey(transportTypes, t.TransportType)
+	} else if t.OtherTransportDesc != "" {
+		meta[MetaKeyTransportType] = t.OtherTransportDesc
+	}
+	if t.CargoType != "" {
+		meta[MetaKeyCargoType] = lookupKey(cargoTypes, t.CargoType)
+	} else if t.OtherCargoDesc != "" {
+		meta[MetaKeyCargoType] = t.OtherCargoDesc
+	}
+	if t.PackagingUnit != "" {
+		meta[MetaKeyPackagingUnit] = t.PackagingUnit
+	}
+
+	if t.TransportOrderNumber != "" {
+		delivery.Identities = append(delivery.Identities, &org.Identity{
+			Key:  IdentityKeyTransportOrder,
+			Code: cbc.Code(t.TransportOrderNumber),
+		})
+	}
+	if id := t.Carrier.toIdentity(); id != nil {
+		delivery.Identities = append(delivery.Identities, id)
+	} else if t.Carrier != nil {
+		d.Warnings = append(d.Warnings, fmt.Sprintf("Fa>WarunkiTransakcji>Transport>Przewoznik: carrier '%s' without identifier was dropped", t.Carrier.Name))
+	}
+
+	if err := t.parseTimes(delivery); err != nil {
+		return err
+	}
+
+	if t.ShipTo != nil {
+		if delivery.Receiver == nil {
+			delivery.Receiver = new(org.Party)
+		}
+		if len(delivery.Receiver.Addresses) == 0 {
+			delivery.Receiver.Addresses = []*org.Address{parseAddress(t.ShipTo)}
+		}
+	}
+
+	return nil
+}
+
+// parseTimes keeps the transport times in the delivery meta, and their dates
+// in the delivery period when both are given. A single time is not taken as
+// the delivery date, which would be reported back as the date of the supply.
+func (t *Transport) parseTimes(delivery *bill.DeliveryDetails) error {
+	var start, end *cal.Date
+	if t.TransportStartTime != "" {
+		(*delivery.Meta)[MetaKeyTransportStart] = t.TransportStartTime
+		d, err := parseDateTime(t.TransportStartTime)
+		if err != nil {
+			return fmt.Errorf("parsing transport start time: %w", err)
+		}
+		start = &d
+	}
+	if t.TransportEndTime != "" {
+		(*delivery.Meta)[MetaKeyTransportEnd] = t.TransportEndTime
+		d, err := parseDateTime(t.TransportEndTime)
+		if err != nil {
+			return fmt.Errorf("parsing transport end time: %w", err)
+		}
+		end = &d
+	}
+
+	if start != nil && end != nil {
+		delivery.Period = &cal.Period{Start: *start, End: *end}
+	}
+	return nil
+}
+
+func (c *Carrier) toIdentity() *org.Identity {
+	if c == nil {
+		return nil
+	}
+
+	id := &org.Identity{
+		Key:   IdentityKeyCarrier,
+		Label: c.Name,
+	}
+	switch {
+	case c.NIP != "":
+		id.Country = l10n.PL.ISO()
+		id.Code = cbc.Code(c.NIP)
+	case c.UEVatNumber != "":
+		id.Country = l10n.ISOCountryCode(c.UECode)
+		id.Code = cbc.Code(c.UEVatNumber)
+	case c.IDNumber != "":
+		id.Country = l10n.ISOCountryCode(c.CountryCode)
+		id.Code = cbc.Code(c.IDNumber)
+	default:
+		// Carriers without an identifier cannot be represented
+		return nil
+	}
+
+	if c.Address != nil {
+		id.Description = addressDescription(c.Address)
+	}
+
+	return id
+}
+
+// lookupKey returns the key for the given code in a code list, or the code
+// itself when not found.
+func lookupKey(list map[string]string, code string) string {
+	for k, v := range list {
+		if v == code {
+			return k
+		}
+	}
+	return code
+}
+
+// parseDateTime parses a KSeF date and time, with or without a time zone,
+// keeping only the date
+func parseDateTime(value string) (cal.Date, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t, err = time.Parse("2006-01-02T15:04:05", value)
+		if err != nil {
+			return cal.Date{}, fmt.Errorf("invalid date time format: %w", err)
+		}
+	}
+	return cal.DateOf(t), nil
+}
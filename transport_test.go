@@ -0,0 +1,270 @@
+package ksef_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/invopop/gobl"
+	ksef "github.com/invopop/gobl.ksef"
+	"github.com/invopop/gobl.ksef/test"
+	"github.com/invopop/gobl/bill"
+	"github.com/invopop/gobl/cal"
+	"github.com/invopop/gobl/cbc"
+	"github.com/invopop/gobl/l10n"
+	"github.com/invopop/gobl/org"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTransport(t *testing.T) {
+	t.Run("should return nil without transport and cargo types", func(t *testing.T) {
+		assert.Nil(t, ksef.NewTransport(nil))
+
+		delivery := &bill.DeliveryDetails{
+			Meta: &cbc.Meta{ksef.MetaKeyTransportType: "road"},
+		}
+		assert.Nil(t, ksef.NewTransport(delivery))
+	})
+
+	t.Run("should map known transport and cargo types", func(t *testing.T) {
+		delivery := &bill.DeliveryDetails{
+			Meta: &cbc.Meta{
+				ksef.MetaKeyTransportType: "rail",
+				ksef.MetaKeyCargoType:     "container",
+			},
+		}
+
+		tr := ksef.NewTransport(delivery)
+		require.NotNil(t, tr)
+		assert.Equal(t, "2", tr.TransportType)
+		assert.Zero(t, tr.OtherTransportType)
+		assert.Equal(t, "7", tr.CargoType)
+		assert.Zero(t, tr.OtherCargoType)
+	})
+
+	t.Run("should map other transport and cargo types", func(t *testing.T) {
+		delivery := &bill.DeliveryDetails{
+			Meta: &cbc.Meta{
+				ksef.MetaKeyTransportType: "Kurier rowerowy",
+				ksef.MetaKeyCargoType:     "Koperta",
+			},
+		}
+
+		tr := ksef.NewTransport(delivery)
+		require.NotNil(t, tr)
+		assert.Empty(t, tr.TransportType)
+		assert.Equal(t, 1, tr.OtherTransportType)
+		assert.Equal(t, "Kurier rowerowy", tr.OtherTransportDesc)
+		assert.Empty(t, tr.CargoType)
+		assert.Equal(t, 1, tr.OtherCargoType)
+		assert.Equal(t, "Koperta", tr.OtherCargoDesc)
+	})
+
+	t.Run("should truncate other transport and cargo descriptions", func(t *testing.T) {
+		delivery := &bill.DeliveryDetails{
+			Meta: &cbc.Meta{
+				ksef.MetaKeyTransportType: strings.Repeat("ż", 60),
+				ksef.MetaKeyCargoType:     strings.Repeat("x", 51),
+			},
+		}
+
+		tr := ksef.NewTransport(delivery)
+		require.NotNil(t, tr)
+		assert.Equal(t, strings.Repeat("ż", 50), tr.OtherTransportDesc)
+		assert.Equal(t, strings.Repeat("x", 50), tr.OtherCargoDesc)
+	})
+
+	t.Run("should map carrier, order number, dates and receiver", func(t *testing.T) {
+		date := cal.MakeDate(2026, 2, 14)
+		delivery := &bill.DeliveryDetails{
+			Receiver: &org.Party{
+				Addresses: []*org.Address{
+					{Street: "ul. Portowa 3", Code: "81-002", Locality: "Gdynia", Country: "PL"},
+				},
+			},
+			Identities: []*org.Identity{
+				{Key: ksef.IdentityKeyTransportOrder, Code: "ZT-1"},
+				{Key: ksef.IdentityKeyCarrier, Country: "US", Code: "98-7654321", Label: "Carrier Inc.", Description: "1 Main St, Boston"},
+			},
+			Date: &date,
+			Meta: &cbc.Meta{
+				ksef.MetaKeyTransportType: "air",
+				ksef.MetaKeyCargoType:     "box",
+				ksef.MetaKeyTransportEnd:  "2026-02-14T15:45:00+01:00",
+			},
+		}
+
+		tr := ksef.NewTransport(delivery)
+		require.NotNil(t, tr)
+		assert.Equal(t, "ZT-1", tr.TransportOrderNumber)
+		assert.Empty(t, tr.TransportStartTime)
+		assert.Equal(t, "2026-02-14T15:45:00+01:00", tr.TransportEndTime)
+		assert.Equal(t, &ksef.Carrier{
+			CountryCode: "US",
+			IDNumber:    "98-7654321",
+			Name:        "Carrier Inc.",
+			Address: &ksef.Address{
+				CountryCode: "US",
+				AddressL1:   "1 Main St, Boston",
+			},
+		}, tr.Carrier)
+		require.NotNil(t, tr.ShipTo)
+		assert.Equal(t, "ul. Portowa 3", tr.ShipTo.AddressL1)
+		assert.Equal(t, "81-002 Gdynia", tr.ShipTo.AddressL2)
+	})
+
+	t.Run("should not make up times from the delivery period", func(t *testing.T) {
+		delivery := &bill.DeliveryDetails{
+			Period: &cal.Period{Start: cal.MakeDate(2026, 2, 13), End: cal.MakeDate(2026, 2, 14)},
+			Meta: &cbc.Meta{
+				ksef.MetaKeyTransportType: "road",
+				ksef.MetaKeyCargoType:     "pallet",
+			},
+		}
+
+		tr := ksef.NewTransport(delivery)
+		require.NotNil(t, tr)
+		assert.Empty(t, tr.TransportStartTime)
+		assert.Empty(t, tr.TransportEndTime)
+	})
+}
+
+func TestValidateTransport(t *testing.T) {
+	load := func(t *testing.T) (*gobl.Envelope, *bill.Invoice) {
+		t.Helper()
+		env, err := test.LoadTestEnvelope("invoice-transport.json")
+		require.NoError(t, err)
+		return env, env.Extract().(*bill.Invoice)
+	}
+
+	t.Run("rejects carriers without address", func(t *testing.T) {
+		env, inv := load(t)
+		for _, id := range inv.Delivery.Identities {
+			if id.Key == ksef.IdentityKeyCarrier {
+				id.Description = ""
+			}
+		}
+
+		_, err := ksef.BuildFavat(env)
+		assert.ErrorContains(t, err, "delivery carrier '5252525252': missing address in description")
+	})
+
+	t.Run("rejects invalid transport times", func(t *testing.T) {
+		env, inv := load(t)
+		(*inv.Delivery.Meta)[ksef.MetaKeyTransportStart] = "2026-02-13"
+
+		_, err := ksef.BuildFavat(env)
+		assert.ErrorContains(t, err, "delivery meta transport-start: invalid date time format")
+	})
+}
+
+func TestParseTransport(t *testing.T) {
+	t.Run("should parse transport into delivery details", func(t *testing.T) {
+		data, err := test.LoadOutputFile("invoice-transport.xml")
+		require.NoError(t, err)
+
+		env, err := ksef.ParseKSeF(data)
+		require.NoError(t, err)
+		inv, ok := env.Extract().(*bill.Invoice)
+		require.True(t, ok)
+
+		require.NotNil(t, inv.Delivery)
+		meta := *inv.Delivery.Meta
+		assert.Equal(t, "road", meta[ksef.MetaKeyTransportType])
+		assert.Equal(t, "pallet", meta[ksef.MetaKeyCargoType])
+		assert.Equal(t, "paleta EUR 1200x800", meta[ksef.MetaKeyPackagingUnit])
+
+		require.NotNil(t, inv.Delivery.Period)
+		assert.Equal(t, "2026-02-13", inv.Delivery.Period.Start.String())
+		assert.Equal(t, "2026-02-14", inv.Delivery.Period.End.String())
+
+		require.Len(t, inv.Delivery.Identities, 2)
+		assert.Equal(t, cbc.Code("ZT/2026/0211"), inv.Delivery.Identities[0].Code)
+		carrier := inv.Delivery.Identities[1]
+		assert.Equal(t, ksef.IdentityKeyCarrier, carrier.Key)
+		assert.Equal(t, l10n.PL.ISO(), carrier.Country)
+		assert.Equal(t, cbc.Code("5252525252"), carrier.Code)
+		assert.Equal(t, "Trans-Pol Sp. z o.o.", carrier.Label)
+
+		require.NotNil(t, inv.Delivery.Receiver)
+		require.Len(t, inv.Delivery.Receiver.Addresses, 1)
+	})
+
+	t.Run("should keep transport times in meta", func(t *testing.T) {
+		data, err := test.LoadOutputFile("invoice-transport.xml")
+		require.NoError(t, err)
+
+		env, err := ksef.ParseKSeF(data)
+		require.NoError(t, err)
+		inv := env.Extract().(*bill.Invoice)
+
+		meta := *inv.Delivery.Meta
+		assert.Equal(t, "2026-02-13T08:00:00+01:00", meta[ksef.MetaKeyTransportStart])
+		assert.Equal(t, "2026-02-14T16:30:00+01:00", meta[ksef.MetaKeyTransportEnd])
+	})
+
+	t.Run("should not take a single transport time as the date of the supply", func(t *testing.T) {
+		data, err := os.ReadFile(filepath.Join(test.GetDataPath(), "ksef.gobl", "invoice-transport.xml"))
+		require.NoError(t, err)
+		startOnly := strings.Replace(string(data),
+			"<DataGodzZakTransportu>2026-02-15T09:00:00+01:00</DataGodzZakTransportu>", "", 1)
+		withPeriod := strings.Replace(startOnly, "<P_2>FV-2026/044</P_2>",
+			"<P_2>FV-2026/044</P_2>\n    <OkresFa>\n      <P_6_Od>2026-02-01</P_6_Od>\n      <P_6_Do>2026-02-15</P_6_Do>\n    </OkresFa>", 1)
+
+		for name, xml := range map[string]string{"without period": startOnly, "with period": withPeriod} {
+			t.Run(name, func(t *testing.T) {
+				env, err := ksef.ParseKSeF([]byte(xml))
+				require.NoError(t, err)
+				inv := env.Extract().(*bill.Invoice)
+				assert.Nil(t, inv.Delivery.Date)
+				assert.Nil(t, inv.Delivery.Period)
+				assert.Equal(t, "2026-02-10T14:30:00+01:00", (*inv.Delivery.Meta)[ksef.MetaKeyTransportStart])
+
+				doc, err := ksef.BuildFavat(env)
+				require.NoError(t, err)
+				assert.Empty(t, doc.Inv.CompletionDate)
+				if name == "with period" {
+					require.NotNil(t, doc.Inv.Period)
+					assert.Equal(t, "2026-02-15", doc.Inv.Period.EndDate)
+				}
+				assert.Equal(t, "2026-02-10T14:30:00+01:00", doc.Inv.TransactionConditions.Transport[0].TransportStartTime)
+				assert.Empty(t, doc.Inv.TransactionConditions.Transport[0].TransportEndTime)
+			})
+		}
+	})
+
+	t.Run("should report carriers without identifier", func(t *testing.T) {
+		data, err := test.LoadOutputFile("invoice-transport.xml")
+		require.NoError(t, err)
+		xml := strings.Replace(string(data), "<NIP>5252525252</NIP>", "<BrakID>1</BrakID>", 1)
+
+		var warnings []string
+		env, err := ksef.ParseKSeF([]byte(xml), ksef.WithWarningHandler(func(w string) {
+			warnings = append(warnings, w)
+		}))
+		require.NoError(t, err)
+
+		inv := env.Extract().(*bill.Invoice)
+		require.Len(t, inv.Delivery.Identities, 1)
+		assert.Equal(t, []string{"Fa>WarunkiTransakcji>Transport>Przewoznik: carrier 'Trans-Pol Sp. z o.o.' without identifier was dropped"}, warnings)
+	})
+
+	t.Run("should report transport entries after the first", func(t *testing.T) {
+		data, err := test.LoadOutputFile("invoice-transport.xml")
+		require.NoError(t, err)
+		xml := string(data)
+		start := strings.Index(xml, "<Transport>")
+		end := strings.Index(xml, "</Transport>") + len("</Transport>")
+		xml = xml[:end] + xml[start:end] + xml[end:]
+
+		var warnings []string
+		_, err = ksef.ParseKSeF([]byte(xml), ksef.WithWarningHandler(func(w string) {
+			warnings = append(warnings, w)
+		}))
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"Fa>WarunkiTransakcji>Transport: only the first of 2 transport entries was kept"}, warnings)
+	})
+}